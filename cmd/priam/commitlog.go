@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/alext29/go-prium/priam"
+	"github.com/pkg/errors"
+)
+
+// commitlogTimestampFormat mirrors the layout priam.Priam.NewTimestamp
+// encodes snapshot and commitlog archive timestamps with.
+const commitlogTimestampFormat = "2006-01-02_150405"
+
+// runInstallCommitlogArchiving implements the "install-commitlog-archiving"
+// subcommand: it writes commitlog_archiving.properties on every configured
+// cassandra host, pointing archive_command back at "archive-commitlog".
+func runInstallCommitlogArchiving(args []string) error {
+	fs := flag.NewFlagSet("install-commitlog-archiving", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	var archiveCmd string
+	fs.StringVar(&archiveCmd, "archive-cmd", "priam archive-commitlog --host $HOST %path %name",
+		"archive_command installed in commitlog_archiving.properties")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p, err := priam.New(cfg.toConfig())
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+	return p.InstallCommitlogArchiving(archiveCmd)
+}
+
+// runArchiveCommitlog implements the "archive-commitlog" subcommand: it is
+// the program cassandra's archive_command invokes for every commitlog
+// segment it closes, per commitlog_archiving.properties installed by
+// "install-commitlog-archiving".
+func runArchiveCommitlog(args []string) error {
+	fs := flag.NewFlagSet("archive-commitlog", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	var host string
+	fs.StringVar(&host, "host", "", "cassandra host the segment was archived from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if host == "" {
+		return errors.New("-host is required")
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: priam archive-commitlog -host <host> <path> <name>")
+	}
+	localPath, segment := fs.Arg(0), fs.Arg(1)
+
+	p, err := priam.New(cfg.toConfig())
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+	return p.ArchiveCommitlog(context.Background(), host, localPath, segment)
+}
+
+// runPruneCommitlogs implements the "prune-commitlogs" subcommand, deleting
+// archived commitlog segments older than -before.
+func runPruneCommitlogs(args []string) error {
+	fs := flag.NewFlagSet("prune-commitlogs", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	var before string
+	fs.StringVar(&before, "before", "", "delete archived commitlog segments older than this timestamp ("+commitlogTimestampFormat+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if before == "" {
+		return errors.New("-before is required")
+	}
+	t, err := time.Parse(commitlogTimestampFormat, before)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing -before %s", before)
+	}
+
+	p, err := priam.New(cfg.toConfig())
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+	return p.PruneCommitlogs(t)
+}
+
+// runRestoreToTime implements the "restore-to-time" subcommand, restoring
+// the keyspace to its state as of -at via the latest snapshot plus replayed
+// commitlog segments.
+func runRestoreToTime(args []string) error {
+	fs := flag.NewFlagSet("restore-to-time", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	var at string
+	fs.StringVar(&at, "at", "", "point in time to restore to ("+commitlogTimestampFormat+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if at == "" {
+		return errors.New("-at is required")
+	}
+	t, err := time.Parse(commitlogTimestampFormat, at)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing -at %s", at)
+	}
+
+	p, err := priam.New(cfg.toConfig())
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+	return p.RestoreToTime(t)
+}
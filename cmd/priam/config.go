@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/alext29/go-prium/priam"
+)
+
+// configFlags binds the Config fields shared by every priam subcommand to
+// command-line flags.
+type configFlags struct {
+	hosts               string
+	cqlshPath           string
+	awsBasePath         string
+	awsRegion           string
+	s3Bucket            string
+	keyspace            string
+	tempDir             string
+	sshUser             string
+	sshKeyPath          string
+	maxParallelHosts    int
+	maxParallelUploads  int
+	backendType         string
+	backendURL          string
+	gcsBucket           string
+	localFSPath         string
+	passphrase          string
+	compression         string
+	commitlogPropsPath  string
+	commitlogRestoreDir string
+}
+
+// registerConfigFlags registers the common Config flags on fs.
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	c := &configFlags{}
+	fs.StringVar(&c.hosts, "hosts", "", "comma-separated list of cassandra hosts")
+	fs.StringVar(&c.cqlshPath, "cqlsh-path", "cqlsh", "path to cqlsh on each cassandra host")
+	fs.StringVar(&c.awsBasePath, "aws-base-path", "", "key prefix backups are stored under")
+	fs.StringVar(&c.awsRegion, "aws-region", "us-east-1", "AWS region")
+	fs.StringVar(&c.s3Bucket, "s3-bucket", "", "destination S3 bucket")
+	fs.StringVar(&c.keyspace, "keyspace", "", "cassandra keyspace")
+	fs.StringVar(&c.tempDir, "temp-dir", "/tmp/priam", "local scratch directory")
+	fs.StringVar(&c.sshUser, "ssh-user", "", "SSH user used to connect to cassandra hosts")
+	fs.StringVar(&c.sshKeyPath, "ssh-key", "", "path to the SSH private key")
+	fs.IntVar(&c.maxParallelHosts, "max-parallel-hosts", 1, "hosts snapshotted/restored concurrently")
+	fs.IntVar(&c.maxParallelUploads, "max-parallel-uploads", 1, "per-host concurrent uploads/downloads")
+	fs.StringVar(&c.backendType, "backend", "", "storage backend: s3, gcs or localfs")
+	fs.StringVar(&c.backendURL, "backend-url", "", "repository URL, e.g. s3://bucket/path")
+	fs.StringVar(&c.gcsBucket, "gcs-bucket", "", "destination GCS bucket")
+	fs.StringVar(&c.localFSPath, "localfs-path", "", "root directory for the localfs backend")
+	fs.StringVar(&c.passphrase, "passphrase", "", "repo passphrase, required once the repo key has been initialized")
+	fs.StringVar(&c.compression, "compression", "none", "compression algo applied before encryption: none, gzip or zstd")
+	fs.StringVar(&c.commitlogPropsPath, "commitlog-properties-path", "/etc/cassandra/commitlog_archiving.properties",
+		"path to commitlog_archiving.properties on each cassandra host")
+	fs.StringVar(&c.commitlogRestoreDir, "commitlog-restore-dir", "/var/lib/cassandra/commitlog_restore",
+		"directory cassandra replays archived commitlog segments from on startup")
+	return c
+}
+
+// toConfig builds a *priam.Config from the parsed flags.
+func (c *configFlags) toConfig() *priam.Config {
+	return &priam.Config{
+		CassandraHosts:          splitHosts(c.hosts),
+		CqlshPath:               c.cqlshPath,
+		AwsBasePath:             c.awsBasePath,
+		AwsRegion:               c.awsRegion,
+		S3Bucket:                c.s3Bucket,
+		Keyspace:                c.keyspace,
+		TempDir:                 c.tempDir,
+		SSHUser:                 c.sshUser,
+		SSHKeyPath:              c.sshKeyPath,
+		MaxParallelHosts:        c.maxParallelHosts,
+		MaxParallelUploads:      c.maxParallelUploads,
+		BackendType:             c.backendType,
+		BackendURL:              c.backendURL,
+		GCSBucket:               c.gcsBucket,
+		LocalFSPath:             c.localFSPath,
+		Passphrase:              c.passphrase,
+		Compression:             c.compression,
+		CommitlogPropertiesPath: c.commitlogPropsPath,
+		CommitlogRestoreDir:     c.commitlogRestoreDir,
+	}
+}
+
+// splitHosts parses a comma-separated host list, dropping empty entries.
+func splitHosts(hosts string) []string {
+	var out []string
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			out = append(out, h)
+		}
+	}
+	return out
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/alext29/go-prium/priam"
+	"github.com/pkg/errors"
+)
+
+// runForget implements the "forget" subcommand, applying a retention
+// policy to the configured keyspace's snapshot history.
+func runForget(args []string) error {
+	fs := flag.NewFlagSet("forget", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+
+	var policy priam.RetentionPolicy
+	fs.IntVar(&policy.KeepLast, "keep-last", 0, "always keep the N most recent snapshots")
+	fs.IntVar(&policy.KeepHourly, "keep-hourly", 0, "keep one snapshot for each of the last N hours")
+	fs.IntVar(&policy.KeepDaily, "keep-daily", 0, "keep one snapshot for each of the last N days")
+	fs.IntVar(&policy.KeepWeekly, "keep-weekly", 0, "keep one snapshot for each of the last N weeks")
+	fs.IntVar(&policy.KeepMonthly, "keep-monthly", 0, "keep one snapshot for each of the last N months")
+	fs.IntVar(&policy.KeepYearly, "keep-yearly", 0, "keep one snapshot for each of the last N years")
+	fs.DurationVar(&policy.KeepWithin, "keep-within", 0, "keep every snapshot newer than this duration")
+	fs.BoolVar(&policy.DryRun, "dry-run", false, "print the prune plan without deleting anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p, err := priam.New(cfg.toConfig())
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+	return p.Forget(policy)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/alext29/go-prium/priam"
+	"github.com/pkg/errors"
+)
+
+// runInit implements the "init" subcommand, creating the repo's data key
+// wrapped with the given passphrase.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.passphrase == "" {
+		return errors.New("-passphrase is required")
+	}
+	return priam.Init(cfg.toConfig(), cfg.passphrase)
+}
+
+// runRotatePassphrase implements the "rotate-passphrase" subcommand,
+// re-wrapping the existing data key under a new passphrase.
+func runRotatePassphrase(args []string) error {
+	fs := flag.NewFlagSet("rotate-passphrase", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	var newPassphrase string
+	fs.StringVar(&newPassphrase, "new-passphrase", "", "passphrase to rotate to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.passphrase == "" || newPassphrase == "" {
+		return errors.New("-passphrase and -new-passphrase are required")
+	}
+	return priam.RotatePassphrase(cfg.toConfig(), cfg.passphrase, newPassphrase)
+}
@@ -0,0 +1,50 @@
+// Command priam is the CLI entry point for the priam library: it wires
+// command-line flags to priam.Config and dispatches to the matching
+// subcommand.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "forget":
+		err = runForget(os.Args[2:])
+	case "init":
+		err = runInit(os.Args[2:])
+	case "rotate-passphrase":
+		err = runRotatePassphrase(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "server":
+		err = runServer(os.Args[2:])
+	case "install-commitlog-archiving":
+		err = runInstallCommitlogArchiving(os.Args[2:])
+	case "archive-commitlog":
+		err = runArchiveCommitlog(os.Args[2:])
+	case "prune-commitlogs":
+		err = runPruneCommitlogs(os.Args[2:])
+	case "restore-to-time":
+		err = runRestoreToTime(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "priam %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: priam <forget|init|rotate-passphrase|verify|server|"+
+		"install-commitlog-archiving|archive-commitlog|prune-commitlogs|restore-to-time> [flags]")
+}
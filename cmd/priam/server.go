@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/alext29/go-prium/priam"
+	"github.com/pkg/errors"
+)
+
+// runServer implements the "server" subcommand: it runs priam as a
+// long-lived daemon until it receives SIGINT/SIGTERM.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+
+	var addr string
+	var schedule priam.ScheduleConfig
+	fs.StringVar(&addr, "addr", ":8080", "address the /metrics, /healthz and /snapshots server listens on")
+	fs.StringVar(&schedule.Full, "schedule-full", "", "cron expression for full backups")
+	fs.StringVar(&schedule.Incremental, "schedule-incremental", "", "cron expression for incremental backups")
+	fs.StringVar(&schedule.Retention, "schedule-retention", "", "cron expression for Forget runs")
+
+	var policy priam.RetentionPolicy
+	fs.IntVar(&policy.KeepLast, "keep-last", 0, "always keep the N most recent snapshots")
+	fs.IntVar(&policy.KeepHourly, "keep-hourly", 0, "keep one snapshot for each of the last N hours")
+	fs.IntVar(&policy.KeepDaily, "keep-daily", 0, "keep one snapshot for each of the last N days")
+	fs.IntVar(&policy.KeepWeekly, "keep-weekly", 0, "keep one snapshot for each of the last N weeks")
+	fs.IntVar(&policy.KeepMonthly, "keep-monthly", 0, "keep one snapshot for each of the last N months")
+	fs.IntVar(&policy.KeepYearly, "keep-yearly", 0, "keep one snapshot for each of the last N years")
+
+	var leaderLock, leaderLockPath string
+	fs.StringVar(&leaderLock, "leader-lock", "none", "leader election mode: none, file or storage")
+	fs.StringVar(&leaderLockPath, "leader-lock-path", "", "shared path flock(2)'d when -leader-lock=file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config := cfg.toConfig()
+	config.Schedule = schedule
+	config.RetentionPolicy = policy
+	config.LeaderLock = leaderLock
+	config.LeaderLockPath = leaderLockPath
+
+	p, err := priam.New(config)
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return p.Serve(ctx, addr)
+}
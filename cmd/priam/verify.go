@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/alext29/go-prium/priam"
+	"github.com/pkg/errors"
+)
+
+// runVerify implements the "verify" subcommand, checking a snapshot
+// against its manifest.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	cfg := registerConfigFlags(fs)
+	var snapshot string
+	var deep bool
+	fs.StringVar(&snapshot, "snapshot", "", "snapshot timestamp to verify")
+	fs.BoolVar(&deep, "deep", false, "re-download and re-hash every file instead of a shallow HEAD check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if snapshot == "" {
+		return errors.New("-snapshot is required")
+	}
+
+	p, err := priam.New(cfg.toConfig())
+	if err != nil {
+		return errors.Wrap(err, "error initializing priam")
+	}
+	return p.Verify(snapshot, deep)
+}
@@ -0,0 +1,50 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// Agent runs commands and transfers files to and from cassandra hosts over
+// SSH.
+type Agent struct {
+	config *Config
+}
+
+// NewAgent returns a new Agent.
+func NewAgent(config *Config) *Agent {
+	return &Agent{config: config}
+}
+
+// Run executes cmd on host and returns its combined output. The command is
+// aborted if ctx is cancelled before it completes.
+func (a *Agent) Run(ctx context.Context, host, cmd string) (string, error) {
+	sshCmd := exec.CommandContext(ctx, "ssh",
+		"-i", a.config.SSHKeyPath,
+		fmt.Sprintf("%s@%s", a.config.SSHUser, host),
+		cmd)
+	out, err := sshCmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "run @ %s: %s", host, string(out))
+	}
+	return string(out), nil
+}
+
+// UploadFile copies localFile to remoteDir on host, aborting if ctx is
+// cancelled before the transfer completes.
+func (a *Agent) UploadFile(ctx context.Context, host, localFile, remoteDir string) error {
+	if _, err := a.Run(ctx, host, fmt.Sprintf("mkdir -p %s", remoteDir)); err != nil {
+		return errors.Wrap(err, "error creating remote directory")
+	}
+	scpCmd := exec.CommandContext(ctx, "scp",
+		"-i", a.config.SSHKeyPath,
+		localFile,
+		fmt.Sprintf("%s@%s:%s", a.config.SSHUser, host, remoteDir))
+	if out, err := scpCmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "upload @ %s: %s", host, string(out))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// Cassandra wraps the nodetool/cqlsh operations needed to snapshot and
+// restore a keyspace.
+type Cassandra struct {
+	config *Config
+	agent  *Agent
+}
+
+// NewCassandra returns a new Cassandra.
+func NewCassandra(config *Config, agent *Agent) *Cassandra {
+	return &Cassandra{config: config, agent: agent}
+}
+
+// Hosts returns the cassandra hosts to operate on.
+func (c *Cassandra) Hosts() []string {
+	return c.config.CassandraHosts
+}
+
+// Snapshot takes a nodetool snapshot of the keyspace on host, returning the
+// snapshotted files and the local directories they live under.
+func (c *Cassandra) Snapshot(ctx context.Context, host, timestamp string) ([]string, map[string]bool, error) {
+	cmd := fmt.Sprintf("nodetool snapshot -t %s %s", timestamp, c.config.Keyspace)
+	if _, err := c.agent.Run(ctx, host, cmd); err != nil {
+		return nil, nil, errors.Wrapf(err, "nodetool snapshot @ %s", host)
+	}
+
+	findCmd := fmt.Sprintf("find /var/lib/cassandra/data/%s -type f -path '*/snapshots/%s/*'",
+		c.config.Keyspace, timestamp)
+	out, err := c.agent.Run(ctx, host, findCmd)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "find snapshot files @ %s", host)
+	}
+
+	dirs := make(map[string]bool)
+	files := parseLines(out)
+	for _, file := range files {
+		dirs[path.Dir(file)] = true
+	}
+	return files, dirs, nil
+}
+
+// deleteSnapshot clears the snapshot tagged timestamp on host once it has
+// been uploaded. It is scoped with nodetool's -t flag so it only clears
+// the snapshot just backed up, not any other snapshot concurrently in
+// progress on the same host.
+func (c *Cassandra) deleteSnapshot(ctx context.Context, host, timestamp string, dirs map[string]bool) error {
+	cmd := fmt.Sprintf("nodetool clearsnapshot -t %s %s", timestamp, c.config.Keyspace)
+	if _, err := c.agent.Run(ctx, host, cmd); err != nil {
+		return errors.Wrapf(err, "clearsnapshot @ %s", host)
+	}
+	return nil
+}
+
+// SchemaBackup dumps the keyspace schema from host and returns the path to
+// the local file it was written to.
+func (c *Cassandra) SchemaBackup(ctx context.Context, host string) (string, error) {
+	cmd := fmt.Sprintf("echo 'DESC KEYSPACE %s;' | %s", c.config.Keyspace, c.config.CqlshPath)
+	out, err := c.agent.Run(ctx, host, cmd)
+	if err != nil {
+		return "", errors.Wrapf(err, "schema dump @ %s", host)
+	}
+	return writeTempFile(c.config.TempDir, c.config.Keyspace+".schema", out)
+}
+
+// sstableload runs sstableloader against every directory in dirs on host.
+func (c *Cassandra) sstableload(ctx context.Context, host string, dirs map[string]bool) error {
+	for dir := range dirs {
+		cmd := fmt.Sprintf("sstableloader %s", dir)
+		if _, err := c.agent.Run(ctx, host, cmd); err != nil {
+			return errors.Wrapf(err, "sstableloader @ %s: %s", host, dir)
+		}
+	}
+	return nil
+}
+
+// parseLines splits command output into non-empty lines.
+func parseLines(out string) []string {
+	var lines []string
+	start := 0
+	for i, r := range out {
+		if r == '\n' {
+			if line := out[start:i]; line != "" {
+				lines = append(lines, line)
+			}
+			start = i + 1
+		}
+	}
+	if line := out[start:]; line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
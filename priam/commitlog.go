@@ -0,0 +1,225 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// commitlogKeySep separates the archive timestamp from the cassandra
+// segment id in a commitlog object key, so ranges of segments can be
+// selected lexicographically the same way snapshot timestamps are.
+const commitlogKeySep = "__"
+
+// commitlogPrefix lives as a sibling of, not inside, the keyspace's
+// snapshot tree ("/<base>/<keyspace>/..."): newSnapshotHistory treats
+// every key under that prefix as belonging to a snapshot, and an archived
+// commitlog key would otherwise be misparsed into a bogus snapshot entry.
+func commitlogPrefix(config *Config, host string) string {
+	return fmt.Sprintf("/%s/%s-commitlogs/%s/", config.AwsBasePath, config.Keyspace, host)
+}
+
+func commitlogKey(config *Config, host, timestamp, segment string) string {
+	return fmt.Sprintf("%s%s%s%s", commitlogPrefix(config, host), timestamp, commitlogKeySep, segment)
+}
+
+// commitlogKeyTime extracts the archive timestamp encoded in key by
+// commitlogKey.
+func commitlogKeyTime(key string) (time.Time, error) {
+	base := path.Base(key)
+	parts := strings.SplitN(base, commitlogKeySep, 2)
+	if len(parts) != 2 {
+		return time.Time{}, fmt.Errorf("malformed commitlog key %s", key)
+	}
+	return time.Parse(snapshotTimestampFormat, parts[0])
+}
+
+// InstallCommitlogArchiving writes a commitlog_archiving.properties on
+// host that shells out to archiveCmd (expected to invoke back into priam,
+// e.g. "priam archive-commitlog --host $HOST %path %name") for every
+// commitlog segment cassandra closes.
+func (c *Cassandra) InstallCommitlogArchiving(ctx context.Context, host, archiveCmd string) error {
+	properties := fmt.Sprintf("archive_command=%s\n", archiveCmd)
+	localFile, err := writeTempFile(c.config.TempDir, "commitlog_archiving.properties", properties)
+	if err != nil {
+		return errors.Wrap(err, "error staging commitlog_archiving.properties")
+	}
+	if err := c.agent.UploadFile(ctx, host, localFile, path.Dir(c.config.CommitlogPropertiesPath)); err != nil {
+		return errors.Wrapf(err, "error installing commitlog archiving @ %s", host)
+	}
+	return nil
+}
+
+// InstallCommitlogArchiving configures every cassandra host to archive
+// closed commitlog segments via archiveCmd (see Cassandra.InstallCommitlogArchiving),
+// enabling point-in-time restore through RestoreToTime.
+func (p *Priam) InstallCommitlogArchiving(archiveCmd string) error {
+	ctx := context.Background()
+	merr := &multiError{}
+	for _, host := range p.cassandra.Hosts() {
+		if err := p.cassandra.InstallCommitlogArchiving(ctx, host, archiveCmd); err != nil {
+			merr.add(errors.Wrapf(err, "error installing commitlog archiving @ %s", host))
+		}
+	}
+	return merr.errOrNil()
+}
+
+// ArchiveCommitlog uploads a closed commitlog segment from host to the
+// keyspace's commitlog prefix. It is the Go-side handler invoked by the
+// archive_command configured via InstallCommitlogArchiving.
+func (p *Priam) ArchiveCommitlog(ctx context.Context, host, localPath, segment string) error {
+	key := commitlogKey(p.config, host, p.NewTimestamp(), segment)
+	if err := p.storage.UploadFile(ctx, host, localPath, key); err != nil {
+		return errors.Wrapf(err, "error archiving commitlog %s @ %s", segment, host)
+	}
+	return nil
+}
+
+// PruneCommitlogs deletes archived commitlog segments older than before,
+// across every configured host, to bound archive growth.
+func (p *Priam) PruneCommitlogs(before time.Time) error {
+	ctx := context.Background()
+	for _, host := range p.cassandra.Hosts() {
+		prefix := commitlogPrefix(p.config, host)
+		keys, err := p.storage.ListPrefix(ctx, prefix)
+		if err != nil {
+			return errors.Wrapf(err, "error listing commitlogs @ %s", host)
+		}
+
+		var prune []string
+		for _, key := range keys {
+			t, err := commitlogKeyTime(key)
+			if err != nil {
+				glog.Warningf("skipping unparseable commitlog key %s: %v", key, err)
+				continue
+			}
+			if t.Before(before) {
+				prune = append(prune, key)
+			}
+		}
+		if len(prune) == 0 {
+			continue
+		}
+		glog.Infof("pruning %d commitlog segment(s) @ %s older than %s", len(prune), host, before)
+		if err := p.storage.DeleteKeys(ctx, prune); err != nil {
+			return errors.Wrapf(err, "error pruning commitlogs @ %s", host)
+		}
+	}
+	return nil
+}
+
+// RestoreToTime restores the keyspace to its state as of t: it loads the
+// latest snapshot at or before t, then replays every archived commitlog
+// segment closed between that snapshot and t.
+func (p *Priam) RestoreToTime(t time.Time) error {
+
+	if err := p.SnapshotHistory(); err != nil {
+		return errors.Wrap(err, "error getting snapshot history")
+	}
+
+	snapshot, snapshotTime, err := p.latestSnapshotBefore(t)
+	if err != nil {
+		return err
+	}
+	glog.Infof("restoring to point in time %s using base snapshot %s", t, snapshot)
+
+	hosts := p.cassandra.Hosts()
+	if len(hosts) == 0 {
+		return fmt.Errorf("did not find valid cassandra hosts")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := p.deleteKeyspace(ctx, hosts[0]); err != nil {
+		return errors.Wrap(err, "error deleting keyspace")
+	}
+	if err := p.createSchema(ctx, hosts[0], snapshot); err != nil {
+		return errors.Wrap(err, "error creating schema")
+	}
+	if err := p.loadSnapshot(ctx, hosts[0], snapshot); err != nil {
+		return errors.Wrap(err, "error loading snapshot")
+	}
+
+	merr := &multiError{}
+	for _, host := range hosts {
+		if err := p.replayCommitlogs(ctx, host, snapshotTime, t); err != nil {
+			merr.add(errors.Wrapf(err, "error replaying commitlogs @ %s", host))
+		}
+	}
+	return merr.errOrNil()
+}
+
+// latestSnapshotBefore returns the newest snapshot timestamp at or before
+// t, along with its parsed time.
+func (p *Priam) latestSnapshotBefore(t time.Time) (string, time.Time, error) {
+	snapshots := append([]string(nil), p.hist.List()...)
+	sort.Strings(snapshots)
+
+	var best string
+	var bestTime time.Time
+	for _, ts := range snapshots {
+		parsed, err := time.Parse(snapshotTimestampFormat, ts)
+		if err != nil {
+			return "", time.Time{}, errors.Wrapf(err, "error parsing snapshot timestamp %s", ts)
+		}
+		if parsed.After(t) {
+			break
+		}
+		best, bestTime = ts, parsed
+	}
+	if best == "" {
+		return "", time.Time{}, fmt.Errorf("no snapshot found at or before %s", t)
+	}
+	return best, bestTime, nil
+}
+
+// replayCommitlogs downloads every commitlog segment for host archived in
+// [since, until], uploads them to the host's commitlog_restore directory
+// and points it at the requested restore point.
+func (p *Priam) replayCommitlogs(ctx context.Context, host string, since, until time.Time) error {
+	keys, err := p.storage.ListPrefix(ctx, commitlogPrefix(p.config, host))
+	if err != nil {
+		return errors.Wrap(err, "error listing commitlogs")
+	}
+
+	var inRange []string
+	for _, key := range keys {
+		t, err := commitlogKeyTime(key)
+		if err != nil {
+			glog.Warningf("skipping unparseable commitlog key %s: %v", key, err)
+			continue
+		}
+		if (t.Equal(since) || t.After(since)) && (t.Equal(until) || t.Before(until)) {
+			inRange = append(inRange, key)
+		}
+	}
+	if len(inRange) == 0 {
+		glog.Infof("no commitlog segments to replay @ %s", host)
+		return nil
+	}
+
+	localTmpDir := fmt.Sprintf("%s/commitlogs", p.config.TempDir)
+	files, err := p.storage.DownloadKeys(ctx, inRange, localTmpDir)
+	if err != nil {
+		return errors.Wrap(err, "error downloading commitlog segments")
+	}
+	for key, localFile := range files {
+		if err := p.agent.UploadFile(ctx, host, localFile, p.config.CommitlogRestoreDir); err != nil {
+			return errors.Wrapf(err, "error uploading commitlog segment %s", key)
+		}
+	}
+
+	cmd := fmt.Sprintf("echo 'restore_point_in_time=%s' >> %s",
+		until.Format(snapshotTimestampFormat), p.config.CommitlogPropertiesPath)
+	if _, err := p.agent.Run(ctx, host, cmd); err != nil {
+		return errors.Wrap(err, "error writing restore_point_in_time")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package priam
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommitlogPrefixIsDisjointFromSnapshotPrefix guards against the
+// archived commitlog prefix living inside the keyspace's snapshot tree:
+// newSnapshotHistory treats every key under "/<base>/<keyspace>/" as
+// belonging to a snapshot, so a commitlog key nested there would be
+// misparsed into a bogus snapshot entry.
+func TestCommitlogPrefixIsDisjointFromSnapshotPrefix(t *testing.T) {
+	config := &Config{AwsBasePath: "backups", Keyspace: "ks"}
+	snapshotPrefix := "/backups/ks/"
+	clPrefix := commitlogPrefix(config, "hostA")
+
+	if strings.HasPrefix(clPrefix, snapshotPrefix) {
+		t.Fatalf("commitlog prefix %q must not live under the snapshot prefix %q", clPrefix, snapshotPrefix)
+	}
+}
+
+func TestCommitlogKeyTime(t *testing.T) {
+	config := &Config{AwsBasePath: "backups", Keyspace: "ks"}
+	ts := "2024-01-01_020000"
+	key := commitlogKey(config, "hostA", ts, "CommitLog-7-1.log")
+
+	got, err := commitlogKeyTime(key)
+	if err != nil {
+		t.Fatalf("commitlogKeyTime(%q) returned error: %v", key, err)
+	}
+	want, err := time.Parse(snapshotTimestampFormat, ts)
+	if err != nil {
+		t.Fatalf("time.Parse(%q): %v", ts, err)
+	}
+	if !got.Equal(want) {
+		t.Fatalf("commitlogKeyTime(%q) = %v, want %v", key, got, want)
+	}
+}
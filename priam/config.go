@@ -0,0 +1,100 @@
+package priam
+
+// Config holds all configuration required to run a Priam backup or restore.
+type Config struct {
+	// CassandraHosts is the list of cassandra nodes to operate on.
+	CassandraHosts []string
+	// CqlshPath is the path to the cqlsh binary on each cassandra host.
+	CqlshPath string
+	// AwsBasePath is the path prefix under which backups are stored in S3.
+	AwsBasePath string
+	// AwsRegion is the AWS region the backup bucket lives in.
+	AwsRegion string
+	// S3Bucket is the destination bucket for backups.
+	S3Bucket string
+	// Keyspace is the cassandra keyspace being backed up or restored.
+	Keyspace string
+	// TempDir is a scratch directory used to stage files locally and
+	// remotely during restore.
+	TempDir string
+	// Snapshot is the snapshot timestamp to restore, leave empty to
+	// restore the latest snapshot.
+	Snapshot string
+	// Incremental, if true, backs up only the data written since the
+	// last snapshot instead of a full snapshot.
+	Incremental bool
+	// SSHUser is the user used to connect to cassandra hosts.
+	SSHUser string
+	// SSHKeyPath is the path to the private key used for SSH auth.
+	SSHKeyPath string
+
+	// MaxParallelHosts bounds how many cassandra hosts are snapshotted,
+	// uploaded and restored to concurrently. Defaults to 1 (sequential)
+	// when unset.
+	MaxParallelHosts int
+	// MaxParallelUploads bounds how many files are uploaded to, or
+	// downloaded from, S3 concurrently for a single host. Defaults to 1
+	// (sequential) when unset.
+	MaxParallelUploads int
+
+	// BackendType selects the storage backend: "s3" (default), "gcs" or
+	// "localfs". Inferred from BackendURL's scheme when unset.
+	BackendType string
+	// BackendURL optionally points priam at a single repository location,
+	// e.g. "s3://bucket/path", "gs://bucket/path" or "file:///mnt/backups".
+	// It is only read when BackendType is unset; it then overrides
+	// S3Bucket or GCSBucket and AwsBasePath (s3://, gs://), or LocalFSPath
+	// (file://).
+	BackendURL string
+	// GCSBucket is the destination bucket for backups stored in GCS.
+	GCSBucket string
+	// LocalFSPath is the root directory backups are stored under when
+	// using the localfs backend.
+	LocalFSPath string
+
+	// Passphrase unlocks the repo's data key (see Init). When set,
+	// every object is transparently compressed and AES-256-GCM encrypted
+	// before it reaches the storage backend.
+	Passphrase string
+	// Compression selects the compression algorithm applied before
+	// encryption: "none" (default), "gzip" or "zstd".
+	Compression string
+
+	// Schedule drives priam server's cron-like scheduler.
+	Schedule ScheduleConfig
+	// RetentionPolicy is applied by priam server on Schedule.Retention.
+	RetentionPolicy RetentionPolicy
+	// LeaderLock selects how priam server elects a leader across
+	// replicas: "none" (default, single replica), "file" or "storage".
+	LeaderLock string
+	// LeaderLockPath is the shared path flock(2)'d when LeaderLock is
+	// "file".
+	LeaderLockPath string
+
+	// CommitlogPropertiesPath is the path to cassandra's
+	// commitlog_archiving.properties on each host, used for point-in-time
+	// restore via Priam.RestoreToTime.
+	CommitlogPropertiesPath string
+	// CommitlogRestoreDir is the directory on each host cassandra replays
+	// commitlog segments from on startup (commitlog_restore in
+	// commitlog_archiving.properties).
+	CommitlogRestoreDir string
+}
+
+// maxParallelHosts returns the configured host concurrency, defaulting to
+// sequential execution when unset.
+func (c *Config) maxParallelHosts() int {
+	if c.MaxParallelHosts <= 0 {
+		return 1
+	}
+	return c.MaxParallelHosts
+}
+
+// maxParallelUploads returns the configured per-host upload/download
+// concurrency, defaulting to sequential execution when unset.
+func (c *Config) maxParallelUploads() int {
+	if c.MaxParallelUploads <= 0 {
+		return 1
+	}
+	return c.MaxParallelUploads
+}
@@ -0,0 +1,313 @@
+package priam
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// objectHeader is prepended to every object written through cryptoStorage
+// so DownloadKey can transparently reverse whatever pipeline UploadFile
+// applied, even if Config.Compression/encryption settings later change.
+type objectHeader struct {
+	compression compressionAlgo
+	encryption  encryptionAlgo
+	nonce       []byte
+}
+
+const (
+	objectMagic       = "PRM1"
+	gcmNonceSize      = 12
+	scryptN           = 1 << 15
+	scryptR           = 8
+	scryptP           = 1
+	scryptKeyLen      = 32
+	repoKeyConfigName = "config"
+)
+
+type compressionAlgo byte
+
+const (
+	compressionNone compressionAlgo = iota
+	compressionGzip
+	compressionZstd
+)
+
+type encryptionAlgo byte
+
+const (
+	encryptionNone encryptionAlgo = iota
+	encryptionAES256GCM
+)
+
+func parseCompressionAlgo(name string) (compressionAlgo, error) {
+	switch name {
+	case "", "none":
+		return compressionNone, nil
+	case "gzip":
+		return compressionGzip, nil
+	case "zstd":
+		return compressionZstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported compression %q", name)
+	}
+}
+
+// repoKey is the per-repo data key, wrapped (encrypted) with a KEK derived
+// from the user's passphrase via scrypt. The data key itself never
+// changes, so rotating the passphrase only re-wraps it.
+type repoKey struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+func repoKeyPath(config *Config) string {
+	return fmt.Sprintf("/%s/%s", config.AwsBasePath, repoKeyConfigName)
+}
+
+// Init creates the repo's data key, wrapping it with a KEK derived from
+// passphrase, and uploads it to config's storage backend. It fails if a
+// repo key already exists; use RotatePassphrase to re-wrap an existing
+// one. Init talks to the raw (unencrypted) storage backend directly since
+// the data key has to exist before any crypto-wrapped storage can be used.
+func Init(config *Config, passphrase string) error {
+	agent := NewAgent(config)
+	storage, err := selectBackend(config, agent)
+	if err != nil {
+		return err
+	}
+
+	if keys, err := storage.ListPrefix(context.Background(), repoKeyPath(config)); err == nil && len(keys) > 0 {
+		return fmt.Errorf("repo key already exists at %s, use RotatePassphrase instead", repoKeyPath(config))
+	}
+
+	dataKey := make([]byte, scryptKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return errors.Wrap(err, "error generating data key")
+	}
+	return writeRepoKey(storage, config, dataKey, passphrase)
+}
+
+// RotatePassphrase unwraps the repo's data key with oldPassphrase and
+// re-wraps it with newPassphrase, so previously encrypted objects remain
+// readable without being re-uploaded.
+func RotatePassphrase(config *Config, oldPassphrase, newPassphrase string) error {
+	agent := NewAgent(config)
+	storage, err := selectBackend(config, agent)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := unwrapDataKey(storage, config, oldPassphrase)
+	if err != nil {
+		return errors.Wrap(err, "error unwrapping repo key with old passphrase")
+	}
+	return writeRepoKey(storage, config, dataKey, newPassphrase)
+}
+
+func writeRepoKey(storage Storage, config *Config, dataKey []byte, passphrase string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return errors.Wrap(err, "error generating salt")
+	}
+	kek, err := deriveKEK(passphrase, salt)
+	if err != nil {
+		return errors.Wrap(err, "error deriving key encryption key")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return errors.Wrap(err, "error creating cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return errors.Wrap(err, "error creating GCM")
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "error generating nonce")
+	}
+	wrapped := gcm.Seal(nil, nonce, dataKey, nil)
+
+	rk := repoKey{Salt: salt, Nonce: nonce, WrappedKey: wrapped}
+	data, err := json.Marshal(rk)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling repo key")
+	}
+
+	localFile, err := writeTempFile(config.TempDir, repoKeyConfigName, string(data))
+	if err != nil {
+		return errors.Wrap(err, "error staging repo key")
+	}
+	if err := storage.UploadFile(context.Background(), "", localFile, repoKeyPath(config)); err != nil {
+		return errors.Wrap(err, "error uploading repo key")
+	}
+	return nil
+}
+
+// unwrapDataKey downloads the repo key object and unwraps it with
+// passphrase, refusing to proceed if the passphrase is wrong.
+func unwrapDataKey(storage Storage, config *Config, passphrase string) ([]byte, error) {
+	localFile, err := storage.DownloadKey(context.Background(), repoKeyPath(config),
+		fmt.Sprintf("%s/local", config.TempDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "error downloading repo key, has priam init been run?")
+	}
+	data, err := ioutil.ReadFile(localFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading repo key")
+	}
+	var rk repoKey
+	if err := json.Unmarshal(data, &rk); err != nil {
+		return nil, errors.Wrap(err, "error parsing repo key")
+	}
+
+	kek, err := deriveKEK(passphrase, rk.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "error deriving key encryption key")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating GCM")
+	}
+	dataKey, err := gcm.Open(nil, rk.Nonce, rk.WrappedKey, nil)
+	if err != nil {
+		return nil, errors.New("incorrect passphrase, could not unwrap repo key")
+	}
+	return dataKey, nil
+}
+
+func deriveKEK(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+}
+
+// compress compresses data per algo.
+func compress(algo compressionAlgo, data []byte) ([]byte, error) {
+	switch algo {
+	case compressionNone:
+		return data, nil
+	case compressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case compressionZstd:
+		w, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer w.Close()
+		return w.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algo %d", algo)
+	}
+}
+
+// decompress reverses compress.
+func decompress(algo compressionAlgo, data []byte) ([]byte, error) {
+	switch algo {
+	case compressionNone:
+		return data, nil
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case compressionZstd:
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return r.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression algo %d", algo)
+	}
+}
+
+// encrypt seals data with dataKey using AES-256-GCM, returning a random
+// nonce and the ciphertext.
+func encrypt(dataKey, data []byte) (nonce, ciphertext []byte, err error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, data, nil), nil
+}
+
+// decrypt reverses encrypt.
+func decrypt(dataKey, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// encodeObject prepends the object header (magic, compression and
+// encryption algo IDs, nonce length, nonce) to payload. The header has no
+// separate version byte.
+func encodeObject(h objectHeader, payload []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(objectMagic)
+	buf.WriteByte(byte(h.compression))
+	buf.WriteByte(byte(h.encryption))
+	buf.WriteByte(byte(len(h.nonce)))
+	buf.Write(h.nonce)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// decodeObject parses the header written by encodeObject and returns it
+// along with the remaining payload.
+func decodeObject(data []byte) (objectHeader, []byte, error) {
+	if len(data) < len(objectMagic)+3 || string(data[:len(objectMagic)]) != objectMagic {
+		return objectHeader{}, nil, fmt.Errorf("not a priam object (missing %q magic)", objectMagic)
+	}
+	i := len(objectMagic)
+	h := objectHeader{
+		compression: compressionAlgo(data[i]),
+		encryption:  encryptionAlgo(data[i+1]),
+	}
+	nonceLen := int(data[i+2])
+	i += 3
+	if len(data) < i+nonceLen {
+		return objectHeader{}, nil, fmt.Errorf("truncated priam object header")
+	}
+	h.nonce = data[i : i+nonceLen]
+	return h, data[i+nonceLen:], nil
+}
@@ -0,0 +1,184 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// cryptoStorage wraps a Storage backend, transparently compressing and
+// encrypting every object written through UploadFile(s) and reversing the
+// pipeline on DownloadKey(s). It works with any backend since the pipeline
+// only ever touches bytes in flight between the local disk and the
+// wrapped Storage.
+type cryptoStorage struct {
+	inner       Storage
+	config      *Config
+	dataKey     []byte
+	compression compressionAlgo
+}
+
+// newCryptoStorage unwraps the repo's data key with Config.Passphrase and
+// returns inner wrapped with client-side compression and encryption.
+func newCryptoStorage(inner Storage, config *Config, agent *Agent) (Storage, error) {
+	dataKey, err := unwrapDataKey(inner, config, config.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := parseCompressionAlgo(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptoStorage{inner: inner, config: config, dataKey: dataKey, compression: compression}, nil
+}
+
+// stagingPath returns the directory and file name UploadFile stages an
+// encrypted object's ciphertext under before handing it to the inner
+// backend. It is keyed by the full destination key, not just localFile's
+// basename: two hosts (or two tables on the same host) can otherwise
+// produce SSTables with the same basename, and concurrent uploads racing
+// on one shared basename-only path would let one upload's ciphertext
+// silently clobber the other's staged file.
+func stagingPath(tempDir, key, localFile string) (dir, name string) {
+	return filepath.Join(tempDir, "upload", filepath.Dir(key)), fmt.Sprintf("%s.enc", filepath.Base(localFile))
+}
+
+func (c *cryptoStorage) UploadFile(ctx context.Context, host, localFile, key string) error {
+	plaintext, err := ioutil.ReadFile(localFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", localFile)
+	}
+
+	compressed, err := compress(c.compression, plaintext)
+	if err != nil {
+		return errors.Wrap(err, "error compressing")
+	}
+	nonce, ciphertext, err := encrypt(c.dataKey, compressed)
+	if err != nil {
+		return errors.Wrap(err, "error encrypting")
+	}
+	object := encodeObject(objectHeader{
+		compression: c.compression,
+		encryption:  encryptionAES256GCM,
+		nonce:       nonce,
+	}, ciphertext)
+
+	stagingDir, stagingName := stagingPath(c.config.TempDir, key, localFile)
+	stagedFile, err := writeTempFile(stagingDir, stagingName, string(object))
+	if err != nil {
+		return errors.Wrap(err, "error staging encrypted object")
+	}
+	return c.inner.UploadFile(ctx, host, stagedFile, key)
+}
+
+func (c *cryptoStorage) UploadFiles(ctx context.Context, parent, timestamp, host string, files []string) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.config.maxParallelUploads())
+	for _, file := range files {
+		file := file
+		key := fmt.Sprintf("/%s/%s/%s/%s/%s%s",
+			c.config.AwsBasePath, c.config.Keyspace, parent, timestamp, host, file)
+		g.Go(func() error {
+			return c.UploadFile(gctx, host, file, key)
+		})
+	}
+	return g.Wait()
+}
+
+func (c *cryptoStorage) DownloadKey(ctx context.Context, key, localDir string) (string, error) {
+	encryptedFile, err := c.inner.DownloadKey(ctx, key, localDir)
+	if err != nil {
+		return "", err
+	}
+	object, err := ioutil.ReadFile(encryptedFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading %s", encryptedFile)
+	}
+
+	header, ciphertext, err := decodeObject(object)
+	if err != nil {
+		return "", errors.Wrapf(err, "error decoding object %s", key)
+	}
+	var compressed []byte
+	switch header.encryption {
+	case encryptionNone:
+		compressed = ciphertext
+	case encryptionAES256GCM:
+		compressed, err = decrypt(c.dataKey, header.nonce, ciphertext)
+		if err != nil {
+			return "", errors.Wrapf(err, "error decrypting %s, wrong passphrase?", key)
+		}
+	default:
+		return "", fmt.Errorf("unsupported encryption algo %d in %s", header.encryption, key)
+	}
+	plaintext, err := decompress(header.compression, compressed)
+	if err != nil {
+		return "", errors.Wrapf(err, "error decompressing %s", key)
+	}
+
+	if err := ioutil.WriteFile(encryptedFile, plaintext, 0644); err != nil {
+		return "", errors.Wrapf(err, "error writing decoded %s", encryptedFile)
+	}
+	return encryptedFile, nil
+}
+
+// DownloadKeys downloads keys using up to Config.MaxParallelUploads
+// concurrent decodes, matching the bounded concurrency every other
+// Storage backend's DownloadKeys provides.
+func (c *cryptoStorage) DownloadKeys(ctx context.Context, keys []string, localDir string) (map[string]string, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.config.maxParallelUploads())
+
+	files := make(map[string]string, len(keys))
+	var mu sync.Mutex
+	for _, key := range keys {
+		key := key
+		g.Go(func() error {
+			localFile, err := c.DownloadKey(gctx, key, filepath.Join(localDir, filepath.Dir(key)))
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			files[key] = localFile
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (c *cryptoStorage) ListPrefix(ctx context.Context, prefix string) ([]string, error) {
+	return c.inner.ListPrefix(ctx, prefix)
+}
+
+// StatKey reports the stored (compressed and encrypted) size of key, not
+// the original plaintext size.
+func (c *cryptoStorage) StatKey(ctx context.Context, key string) (int64, bool, error) {
+	return c.inner.StatKey(ctx, key)
+}
+
+func (c *cryptoStorage) DeleteKeys(ctx context.Context, keys []string) error {
+	return c.inner.DeleteKeys(ctx, keys)
+}
+
+func (c *cryptoStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	return c.inner.DeletePrefix(ctx, prefix)
+}
+
+func (c *cryptoStorage) SnapshotHistory(ctx context.Context) (*SnapshotHistory, error) {
+	return c.inner.SnapshotHistory(ctx)
+}
+
+// CreateIfAbsent passes through to inner uncompressed and unencrypted: it
+// is used for lock objects (see storageLeaderElector), not backup data.
+func (c *cryptoStorage) CreateIfAbsent(ctx context.Context, key, localFile string) (bool, error) {
+	return c.inner.CreateIfAbsent(ctx, key, localFile)
+}
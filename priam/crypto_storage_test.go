@@ -0,0 +1,28 @@
+package priam
+
+import "testing"
+
+// TestStagingPathDistinguishesSameBasename guards against
+// cryptoStorage.UploadFile staging ciphertext under a path keyed only by
+// localFile's basename: two hosts with an SSTable of the same basename
+// (plausible, since each node's SSTable generation counter is independent)
+// must stage to different paths, or a concurrent upload racing on one
+// shared basename-only path could let one upload's ciphertext silently
+// clobber the other's staged file.
+func TestStagingPathDistinguishesSameBasename(t *testing.T) {
+	localFile := "/var/lib/cassandra/data/ks/t/mc-1-big-Data.db"
+	keyA := "/backups/ks/ts/ts/hostA/mc-1-big-Data.db"
+	keyB := "/backups/ks/ts/ts/hostB/mc-1-big-Data.db"
+
+	dirA, nameA := stagingPath("/tmp/priam", keyA, localFile)
+	dirB, nameB := stagingPath("/tmp/priam", keyB, localFile)
+
+	if dirA == dirB {
+		t.Fatalf("stagingPath() for hostA and hostB keys returned the same directory %q; "+
+			"concurrent uploads of same-basename SSTables would collide", dirA)
+	}
+	// name is expected to match, since the collision is avoided via dir.
+	if nameA != nameB {
+		t.Fatalf("stagingPath() name = %q vs %q, want equal", nameA, nameB)
+	}
+}
@@ -0,0 +1,93 @@
+package priam
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	dataKey := make([]byte, scryptKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatalf("error generating data key: %v", err)
+	}
+	plaintext := []byte("hello sstable data")
+
+	nonce, ciphertext, err := encrypt(dataKey, plaintext)
+	if err != nil {
+		t.Fatalf("encrypt() returned error: %v", err)
+	}
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Fatalf("ciphertext must not equal plaintext")
+	}
+
+	got, err := decrypt(dataKey, nonce, ciphertext)
+	if err != nil {
+		t.Fatalf("decrypt() returned error: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypt(encrypt(x)) = %q, want %q", got, plaintext)
+	}
+}
+
+func TestDecryptWrongKeyFails(t *testing.T) {
+	dataKey := make([]byte, scryptKeyLen)
+	wrongKey := make([]byte, scryptKeyLen)
+	rand.Read(dataKey)
+	rand.Read(wrongKey)
+
+	nonce, ciphertext, err := encrypt(dataKey, []byte("secret"))
+	if err != nil {
+		t.Fatalf("encrypt() returned error: %v", err)
+	}
+	if _, err := decrypt(wrongKey, nonce, ciphertext); err == nil {
+		t.Fatalf("expected decrypt with the wrong key to fail")
+	}
+}
+
+func TestCompressDecompressRoundTrip(t *testing.T) {
+	plaintext := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	for _, algo := range []compressionAlgo{compressionNone, compressionGzip, compressionZstd} {
+		compressed, err := compress(algo, plaintext)
+		if err != nil {
+			t.Fatalf("compress(%d) returned error: %v", algo, err)
+		}
+		got, err := decompress(algo, compressed)
+		if err != nil {
+			t.Fatalf("decompress(%d) returned error: %v", algo, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("decompress(compress(x, %d)) = %q, want %q", algo, got, plaintext)
+		}
+	}
+}
+
+func TestEncodeDecodeObjectRoundTrip(t *testing.T) {
+	header := objectHeader{
+		compression: compressionGzip,
+		encryption:  encryptionAES256GCM,
+		nonce:       []byte("0123456789ab"),
+	}
+	payload := []byte("ciphertext bytes")
+
+	encoded := encodeObject(header, payload)
+	gotHeader, gotPayload, err := decodeObject(encoded)
+	if err != nil {
+		t.Fatalf("decodeObject() returned error: %v", err)
+	}
+	if gotHeader.compression != header.compression || gotHeader.encryption != header.encryption {
+		t.Fatalf("decodeObject() header = %+v, want %+v", gotHeader, header)
+	}
+	if !bytes.Equal(gotHeader.nonce, header.nonce) {
+		t.Fatalf("decodeObject() nonce = %x, want %x", gotHeader.nonce, header.nonce)
+	}
+	if !bytes.Equal(gotPayload, payload) {
+		t.Fatalf("decodeObject() payload = %q, want %q", gotPayload, payload)
+	}
+}
+
+func TestDecodeObjectRejectsMissingMagic(t *testing.T) {
+	if _, _, err := decodeObject([]byte("not a priam object at all")); err == nil {
+		t.Fatalf("expected decodeObject to reject data without the priam magic")
+	}
+}
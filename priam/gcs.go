@@ -0,0 +1,216 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// GCS stores and retrieves backup artifacts from a Google Cloud Storage
+// bucket.
+type GCS struct {
+	config *Config
+	client *storage.Client
+}
+
+// NewGCS returns a new GCS backend.
+func NewGCS(config *Config, agent *Agent) (*GCS, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating GCS client")
+	}
+	return &GCS{config: config, client: client}, nil
+}
+
+func (s *GCS) bucket() *storage.BucketHandle {
+	return s.client.Bucket(s.config.GCSBucket)
+}
+
+// UploadFile uploads localFile to key in the backup bucket.
+func (s *GCS) UploadFile(ctx context.Context, host, localFile, key string) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", localFile)
+	}
+	defer f.Close()
+
+	w := s.bucket().Object(key).NewWriter(ctx)
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return errors.Wrapf(err, "error uploading %s to %s", localFile, key)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "error finalizing upload of %s", key)
+	}
+	return nil
+}
+
+// UploadFiles uploads files for host under the parent/timestamp prefix,
+// using up to Config.MaxParallelUploads concurrent uploads.
+func (s *GCS) UploadFiles(ctx context.Context, parent, timestamp, host string, files []string) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.config.maxParallelUploads())
+
+	for _, file := range files {
+		file := file
+		key := fmt.Sprintf("/%s/%s/%s/%s/%s%s",
+			s.config.AwsBasePath, s.config.Keyspace, parent, timestamp, host, file)
+		g.Go(func() error {
+			return s.UploadFile(gctx, host, file, key)
+		})
+	}
+	return g.Wait()
+}
+
+// DownloadKey downloads key to localDir and returns the local path.
+func (s *GCS) DownloadKey(ctx context.Context, key, localDir string) (string, error) {
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "error creating %s", localDir)
+	}
+	localFile := filepath.Join(localDir, path.Base(key))
+
+	r, err := s.bucket().Object(key).NewReader(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "error opening %s", key)
+	}
+	defer r.Close()
+
+	f, err := os.Create(localFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating %s", localFile)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return "", errors.Wrapf(err, "error downloading %s", key)
+	}
+	return localFile, nil
+}
+
+// DownloadKeys downloads keys to localDir, using up to
+// Config.MaxParallelUploads concurrent downloads.
+func (s *GCS) DownloadKeys(ctx context.Context, keys []string, localDir string) (map[string]string, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.config.maxParallelUploads())
+
+	files := make(map[string]string, len(keys))
+	var mu sync.Mutex
+	for _, key := range keys {
+		key := key
+		g.Go(func() error {
+			localFile, err := s.DownloadKey(gctx, key, filepath.Join(localDir, path.Dir(key)))
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			files[key] = localFile
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListPrefix returns every object key stored under prefix.
+func (s *GCS) ListPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	it := s.bucket().Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing prefix %s", prefix)
+		}
+		keys = append(keys, obj.Name)
+	}
+	return keys, nil
+}
+
+// StatKey reports the stored size of key.
+func (s *GCS) StatKey(ctx context.Context, key string) (int64, bool, error) {
+	attrs, err := s.bucket().Object(key).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "error statting %s", key)
+	}
+	return attrs.Size, true, nil
+}
+
+// DeleteKeys deletes keys from the backup bucket, one object at a time.
+func (s *GCS) DeleteKeys(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := s.bucket().Object(key).Delete(ctx); err != nil {
+			return errors.Wrapf(err, "error deleting %s", key)
+		}
+	}
+	return nil
+}
+
+// DeletePrefix removes every object under prefix.
+func (s *GCS) DeletePrefix(ctx context.Context, prefix string) error {
+	keys, err := s.ListPrefix(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return s.DeleteKeys(ctx, keys)
+}
+
+// CreateIfAbsent uploads localFile to key only if key does not already
+// exist, using a DoesNotExist precondition so two callers racing to
+// create the same key can never both succeed.
+func (s *GCS) CreateIfAbsent(ctx context.Context, key, localFile string) (bool, error) {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "error opening %s", localFile)
+	}
+	defer f.Close()
+
+	w := s.bucket().Object(key).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return false, errors.Wrapf(err, "error creating %s", key)
+	}
+	if err := w.Close(); err != nil {
+		if isPreconditionFailed(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "error finalizing creation of %s", key)
+	}
+	return true, nil
+}
+
+// isPreconditionFailed reports whether err is a GCS precondition failure,
+// i.e. the object already exists.
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+// SnapshotHistory fetches and parses the list of snapshots present in the
+// backup bucket.
+func (s *GCS) SnapshotHistory(ctx context.Context) (*SnapshotHistory, error) {
+	prefix := fmt.Sprintf("/%s/%s/", s.config.AwsBasePath, s.config.Keyspace)
+	keys, err := s.ListPrefix(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing snapshot history")
+	}
+	return newSnapshotHistory(prefix, keys), nil
+}
@@ -0,0 +1,165 @@
+package priam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+)
+
+// leaderLeaseTTL bounds how long a leader's lock is honoured before
+// another replica is allowed to take over, in case the leader died
+// without releasing it.
+const leaderLeaseTTL = 30 * time.Second
+
+// LeaderElector decides whether this Priam replica is allowed to run
+// scheduled work, so multiple replicas can be deployed for HA without
+// double-running backups.
+type LeaderElector interface {
+	// IsLeader reports whether this replica currently holds the lock.
+	IsLeader(ctx context.Context) (bool, error)
+}
+
+// singleReplicaElector always reports true, used when no leader election
+// is configured (a single Priam replica).
+type singleReplicaElector struct{}
+
+func (singleReplicaElector) IsLeader(ctx context.Context) (bool, error) { return true, nil }
+
+// fileLeaderElector uses a flock(2) advisory lock on a shared path (e.g. an
+// NFS mount all replicas can see) to elect a leader.
+type fileLeaderElector struct {
+	lock *flock.Flock
+}
+
+func newFileLeaderElector(path string) *fileLeaderElector {
+	return &fileLeaderElector{lock: flock.New(path)}
+}
+
+func (f *fileLeaderElector) IsLeader(ctx context.Context) (bool, error) {
+	if f.lock.Locked() {
+		return true, nil
+	}
+	locked, err := f.lock.TryLock()
+	if err != nil {
+		return false, errors.Wrap(err, "error acquiring leader file lock")
+	}
+	return locked, nil
+}
+
+// storageLeaderElector elects a leader via a lease object written to the
+// backup bucket, renewed on every IsLeader call it wins. This is a
+// best-effort lease, not a strict fencing token: a replica that stalls
+// past leaderLeaseTTL can be superseded by another replica mid-operation.
+type storageLeaderElector struct {
+	storage  Storage
+	key      string
+	identity string
+}
+
+func newStorageLeaderElector(storage Storage, basePath, identity string) *storageLeaderElector {
+	return &storageLeaderElector{
+		storage:  storage,
+		key:      fmt.Sprintf("/%s/leader.lock", basePath),
+		identity: identity,
+	}
+}
+
+type leaseRecord struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *storageLeaderElector) IsLeader(ctx context.Context) (bool, error) {
+	localDir := fmt.Sprintf("/tmp/priam-leader-%d", os.Getpid())
+	current, exists, err := s.readLease(ctx, localDir)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return s.claim(ctx, localDir)
+	}
+	if current.Holder == s.identity {
+		// Only the current holder ever takes this branch, so there is no
+		// other replica racing it: a plain overwrite to renew is safe.
+		return s.renew(ctx, localDir)
+	}
+	if time.Now().Before(current.ExpiresAt) {
+		return false, nil
+	}
+
+	// The lease is stale. Best-effort takeover: delete it and race every
+	// other replica to re-create it via CreateIfAbsent. The delete+create
+	// pair isn't perfectly atomic, but it only runs on the rare
+	// already-expired path, not on every steady-state check, so the
+	// window for two replicas both winning is far narrower than the
+	// unconditional overwrite this replaces.
+	if err := s.storage.DeleteKeys(ctx, []string{s.key}); err != nil {
+		return false, errors.Wrap(err, "error clearing stale leader lease")
+	}
+	return s.claim(ctx, localDir)
+}
+
+// readLease downloads and parses the current lease, reporting
+// exists=false (no error) when no lease object has been written yet.
+func (s *storageLeaderElector) readLease(ctx context.Context, localDir string) (leaseRecord, bool, error) {
+	var current leaseRecord
+	localFile, err := s.storage.DownloadKey(ctx, s.key, localDir)
+	if err != nil {
+		return leaseRecord{}, false, nil
+	}
+	data, err := ioutil.ReadFile(localFile)
+	if err != nil {
+		return leaseRecord{}, false, errors.Wrap(err, "error reading leader lease")
+	}
+	if err := json.Unmarshal(data, &current); err != nil {
+		return leaseRecord{}, false, errors.Wrap(err, "error parsing leader lease")
+	}
+	return current, true, nil
+}
+
+// claim conditionally creates the lease object, so two replicas racing to
+// claim a free lock can never both win.
+func (s *storageLeaderElector) claim(ctx context.Context, localDir string) (bool, error) {
+	leaseFile, err := s.stageLease(localDir)
+	if err != nil {
+		return false, err
+	}
+	created, err := s.storage.CreateIfAbsent(ctx, s.key, leaseFile)
+	if err != nil {
+		return false, errors.Wrap(err, "error claiming leader lease")
+	}
+	return created, nil
+}
+
+// renew overwrites the lease this replica already holds, extending its
+// expiry.
+func (s *storageLeaderElector) renew(ctx context.Context, localDir string) (bool, error) {
+	leaseFile, err := s.stageLease(localDir)
+	if err != nil {
+		return false, err
+	}
+	if err := s.storage.UploadFile(ctx, "", leaseFile, s.key); err != nil {
+		return false, errors.Wrap(err, "error renewing leader lease")
+	}
+	return true, nil
+}
+
+func (s *storageLeaderElector) stageLease(localDir string) (string, error) {
+	lease := leaseRecord{Holder: s.identity, ExpiresAt: time.Now().Add(leaderLeaseTTL)}
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling leader lease")
+	}
+	leaseFile, err := writeTempFile(localDir, "lease.json", string(data))
+	if err != nil {
+		return "", errors.Wrap(err, "error staging leader lease")
+	}
+	return leaseFile, nil
+}
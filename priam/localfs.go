@@ -0,0 +1,213 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// LocalFS stores and retrieves backup artifacts on a local (or NFS
+// mounted) filesystem rooted at Config.LocalFSPath. It exists mainly for
+// tests and for operators who back up to on-prem storage rather than a
+// cloud object store.
+type LocalFS struct {
+	config *Config
+}
+
+// NewLocalFS returns a new LocalFS.
+func NewLocalFS(config *Config, agent *Agent) *LocalFS {
+	return &LocalFS{config: config}
+}
+
+func (l *LocalFS) path(key string) string {
+	return filepath.Join(l.config.LocalFSPath, key)
+}
+
+// UploadFile copies localFile to key under LocalFSPath.
+func (l *LocalFS) UploadFile(ctx context.Context, host, localFile, key string) error {
+	dest := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return errors.Wrapf(err, "error creating %s", filepath.Dir(dest))
+	}
+	return copyFile(localFile, dest)
+}
+
+// UploadFiles copies files for host under the parent/timestamp prefix.
+func (l *LocalFS) UploadFiles(ctx context.Context, parent, timestamp, host string, files []string) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(l.config.maxParallelUploads())
+
+	for _, file := range files {
+		file := file
+		key := fmt.Sprintf("/%s/%s/%s/%s/%s%s",
+			l.config.AwsBasePath, l.config.Keyspace, parent, timestamp, host, file)
+		g.Go(func() error {
+			return l.UploadFile(gctx, host, file, key)
+		})
+	}
+	return g.Wait()
+}
+
+// DownloadKey copies key from LocalFSPath to localDir and returns the
+// local path.
+func (l *LocalFS) DownloadKey(ctx context.Context, key, localDir string) (string, error) {
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "error creating %s", localDir)
+	}
+	localFile := filepath.Join(localDir, path.Base(key))
+	if err := copyFile(l.path(key), localFile); err != nil {
+		return "", err
+	}
+	return localFile, nil
+}
+
+// DownloadKeys copies keys to localDir, returning a map of key to local
+// path.
+func (l *LocalFS) DownloadKeys(ctx context.Context, keys []string, localDir string) (map[string]string, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(l.config.maxParallelUploads())
+
+	files := make(map[string]string, len(keys))
+	var mu sync.Mutex
+	for _, key := range keys {
+		key := key
+		g.Go(func() error {
+			localFile, err := l.DownloadKey(gctx, key, filepath.Join(localDir, path.Dir(key)))
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			files[key] = localFile
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListPrefix returns every key stored under prefix.
+func (l *LocalFS) ListPrefix(ctx context.Context, prefix string) ([]string, error) {
+	root := l.path(prefix)
+	var keys []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.config.LocalFSPath, p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, "/"+filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing prefix %s", prefix)
+	}
+	return keys, nil
+}
+
+// StatKey reports the size of key on disk.
+func (l *LocalFS) StatKey(ctx context.Context, key string) (int64, bool, error) {
+	info, err := os.Stat(l.path(key))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "error statting %s", key)
+	}
+	return info.Size(), true, nil
+}
+
+// DeleteKeys removes keys from LocalFSPath.
+func (l *LocalFS) DeleteKeys(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(l.path(key)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error deleting %s", key)
+		}
+	}
+	return nil
+}
+
+// DeletePrefix removes every file under prefix.
+func (l *LocalFS) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := os.RemoveAll(l.path(prefix)); err != nil {
+		return errors.Wrapf(err, "error deleting prefix %s", prefix)
+	}
+	return nil
+}
+
+// CreateIfAbsent copies localFile to key only if key does not already
+// exist, using O_EXCL so two callers racing to create the same key can
+// never both succeed.
+func (l *LocalFS) CreateIfAbsent(ctx context.Context, key, localFile string) (bool, error) {
+	dest := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, errors.Wrapf(err, "error creating %s", filepath.Dir(dest))
+	}
+
+	in, err := os.Open(localFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "error opening %s", localFile)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "error creating %s", dest)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return false, errors.Wrapf(err, "error copying %s to %s", localFile, dest)
+	}
+	return true, nil
+}
+
+// SnapshotHistory fetches and parses the snapshot history.
+func (l *LocalFS) SnapshotHistory(ctx context.Context) (*SnapshotHistory, error) {
+	prefix := fmt.Sprintf("/%s/%s/", l.config.AwsBasePath, l.config.Keyspace)
+	keys, err := l.ListPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotHistory(prefix, keys), nil
+}
+
+// copyFile copies src to dst, overwriting dst if it exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", src)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", dst)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Wrapf(err, "error copying %s to %s", src, dst)
+	}
+	return nil
+}
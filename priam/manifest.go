@@ -0,0 +1,218 @@
+package priam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+const manifestName = "manifest.json"
+
+// manifestEntry records everything Verify needs to check one uploaded
+// object: its key, the cassandra component it belongs to, the size
+// actually stored (post compression/encryption) and the SHA-256 of its
+// plaintext content.
+type manifestEntry struct {
+	Key       string `json:"key"`
+	Host      string `json:"host"`
+	Component string `json:"component"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// manifest lists every object belonging to a single snapshot.
+type manifest struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+func manifestKey(config *Config, parent, timestamp string) string {
+	return fmt.Sprintf("/%s/%s/%s/%s/%s", config.AwsBasePath, config.Keyspace, parent, timestamp, manifestName)
+}
+
+// digestFile hashes localFile and returns its size and hex-encoded
+// SHA-256.
+func digestFile(localFile string) (int64, string, error) {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "error opening %s", localFile)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "error hashing %s", localFile)
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildManifestEntries digests files for host, stats their already
+// uploaded object keys for the stored size, and returns the resulting
+// manifest entries.
+func buildManifestEntries(ctx context.Context, storage Storage, config *Config,
+	parent, timestamp, host string, files []string) ([]manifestEntry, error) {
+
+	entries := make([]manifestEntry, 0, len(files))
+	for _, file := range files {
+		key := fmt.Sprintf("/%s/%s/%s/%s/%s%s",
+			config.AwsBasePath, config.Keyspace, parent, timestamp, host, file)
+
+		_, digest, err := digestFile(file)
+		if err != nil {
+			return nil, err
+		}
+		size, exists, err := storage.StatKey(ctx, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error statting uploaded object %s", key)
+		}
+		if !exists {
+			return nil, fmt.Errorf("object %s missing right after upload", key)
+		}
+		entries = append(entries, manifestEntry{
+			Key:       key,
+			Host:      host,
+			Component: path.Base(file),
+			Size:      size,
+			SHA256:    digest,
+		})
+	}
+	return entries, nil
+}
+
+// writeManifest marshals and uploads the manifest for a snapshot.
+func writeManifest(ctx context.Context, storage Storage, config *Config,
+	parent, timestamp string, entries []manifestEntry) error {
+
+	data, err := json.Marshal(manifest{Entries: entries})
+	if err != nil {
+		return errors.Wrap(err, "error marshaling manifest")
+	}
+	localFile, err := writeTempFile(config.TempDir, manifestName, string(data))
+	if err != nil {
+		return errors.Wrap(err, "error staging manifest")
+	}
+	if err := storage.UploadFile(ctx, "", localFile, manifestKey(config, parent, timestamp)); err != nil {
+		return errors.Wrap(err, "error uploading manifest")
+	}
+	return nil
+}
+
+// readManifest downloads and parses the manifest for snapshot.
+func readManifest(ctx context.Context, storage Storage, config *Config, parent, timestamp string) (*manifest, error) {
+	localFile, err := storage.DownloadKey(ctx, manifestKey(config, parent, timestamp),
+		fmt.Sprintf("%s/local", config.TempDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "error downloading manifest")
+	}
+	data, err := ioutil.ReadFile(localFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading manifest")
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "error parsing manifest")
+	}
+	return &m, nil
+}
+
+// Verify checks that every object referenced by snapshot's manifest is
+// intact. In shallow mode it confirms each object exists with its
+// recorded stored size (a HEAD request); in deep mode it re-downloads and
+// re-hashes every object to detect bit rot, analogous to
+// "restic check --read-data".
+func (p *Priam) Verify(snapshot string, deep bool) error {
+
+	if err := p.SnapshotHistory(); err != nil {
+		return errors.Wrap(err, "error getting snapshot history")
+	}
+	if !p.hist.Valid(snapshot) {
+		return fmt.Errorf("%s is not a valid snapshot", snapshot)
+	}
+	parent := p.hist.Parent(snapshot)
+
+	ctx := context.Background()
+	m, err := readManifest(ctx, p.storage, p.config, parent, snapshot)
+	if err != nil {
+		return errors.Wrap(err, "error reading manifest")
+	}
+
+	merr := &multiError{}
+	for _, entry := range m.Entries {
+		if err := p.verifyEntry(ctx, entry, deep); err != nil {
+			merr.add(err)
+		}
+	}
+	return merr.errOrNil()
+}
+
+// verifyDownloadedFiles re-hashes every downloaded file and checks it
+// against snapshot's manifest before loadSnapshot hands it to
+// sstableloader.
+func (p *Priam) verifyDownloadedFiles(ctx context.Context, snapshot string, files map[string]string) error {
+	parent := p.hist.Parent(snapshot)
+	m, err := readManifest(ctx, p.storage, p.config, parent, snapshot)
+	if err != nil {
+		return errors.Wrap(err, "error reading manifest")
+	}
+
+	digests := make(map[string]string, len(m.Entries))
+	for _, entry := range m.Entries {
+		digests[entry.Key] = entry.SHA256
+	}
+
+	merr := &multiError{}
+	for key, localFile := range files {
+		want, ok := digests[key]
+		if !ok {
+			continue // not every downloaded key (e.g. the schema) is in the manifest
+		}
+		_, got, err := digestFile(localFile)
+		if err != nil {
+			merr.add(errors.Wrapf(err, "error hashing %s", key))
+			continue
+		}
+		if got != want {
+			merr.add(fmt.Errorf("%s: sha256 %s does not match manifest %s, possible bit rot", key, got, want))
+		}
+	}
+	return merr.errOrNil()
+}
+
+func (p *Priam) verifyEntry(ctx context.Context, entry manifestEntry, deep bool) error {
+	if !deep {
+		size, exists, err := p.storage.StatKey(ctx, entry.Key)
+		if err != nil {
+			return errors.Wrapf(err, "%s/%s/%s: error statting %s", p.config.Keyspace, entry.Host, entry.Component, entry.Key)
+		}
+		if !exists {
+			return fmt.Errorf("%s/%s/%s: object %s is missing", p.config.Keyspace, entry.Host, entry.Component, entry.Key)
+		}
+		if size != entry.Size {
+			return fmt.Errorf("%s/%s/%s: object %s size %d does not match recorded size %d",
+				p.config.Keyspace, entry.Host, entry.Component, entry.Key, size, entry.Size)
+		}
+		return nil
+	}
+
+	localFile, err := p.storage.DownloadKey(ctx, entry.Key, fmt.Sprintf("%s/verify", p.config.TempDir))
+	if err != nil {
+		return errors.Wrapf(err, "%s/%s/%s: error downloading %s", p.config.Keyspace, entry.Host, entry.Component, entry.Key)
+	}
+	_, digest, err := digestFile(localFile)
+	if err != nil {
+		return errors.Wrapf(err, "%s/%s/%s: error hashing %s", p.config.Keyspace, entry.Host, entry.Component, entry.Key)
+	}
+	if digest != entry.SHA256 {
+		return fmt.Errorf("%s/%s/%s: object %s sha256 %s does not match recorded %s",
+			p.config.Keyspace, entry.Host, entry.Component, entry.Key, digest, entry.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package priam
+
+import "strings"
+
+// multiError aggregates the errors produced by concurrent per-host work so
+// a single host failure doesn't hide the others.
+type multiError struct {
+	errs []error
+}
+
+func (m *multiError) add(err error) {
+	if err != nil {
+		m.errs = append(m.errs, err)
+	}
+}
+
+// errOrNil returns m as an error if it holds any, otherwise nil.
+func (m *multiError) errOrNil() error {
+	if len(m.errs) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (m *multiError) Error() string {
+	msgs := make([]string, len(m.errs))
+	for i, err := range m.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
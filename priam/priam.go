@@ -1,32 +1,41 @@
 package priam
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Priam object provides backup and restore of cassandra DB to AWS S3.
+// Priam object provides backup and restore of cassandra DB to pluggable
+// storage (S3, GCS or a local filesystem).
 type Priam struct {
 	agent     *Agent
 	cassandra *Cassandra
 	config    *Config
-	s3        *S3
+	storage   Storage
 	hist      *SnapshotHistory
 }
 
-// New returns a new Priam object.
-func New(config *Config) *Priam {
+// New returns a new Priam object, selecting a storage backend per
+// Config.BackendType/BackendURL.
+func New(config *Config) (*Priam, error) {
 	agent := NewAgent(config)
+	storage, err := newStorage(config, agent)
+	if err != nil {
+		return nil, errors.Wrap(err, "error selecting storage backend")
+	}
 	return &Priam{
 		agent:     agent,
 		config:    config,
 		cassandra: NewCassandra(config, agent),
-		s3:        NewS3(config, agent),
-	}
+		storage:   storage,
+	}, nil
 }
 
 // History prints the current list of backups in S3.
@@ -41,7 +50,11 @@ func (p *Priam) History() error {
 }
 
 // Backup flushes all cassandra tables to disk identifies the appropriate
-// files and copies them to the specified AWS S3 bucket.
+// files and copies them to the specified AWS S3 bucket. Hosts are
+// snapshotted, uploaded and cleaned up concurrently, bounded by
+// Config.MaxParallelHosts. The first host failure cancels the remaining
+// hosts, and the partially written snapshot prefix is rolled back from
+// storage so SnapshotHistory never sees a torn backup.
 func (p *Priam) Backup() error {
 
 	glog.Infof("start taking backup...")
@@ -78,39 +91,110 @@ func (p *Priam) Backup() error {
 	}
 	glog.Infof("timestamp of parent snapshot: %s", parent)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// perform schema backup
-	if err := p.schemaBackup(parent, timestamp, hosts[0]); err != nil {
+	if err := p.schemaBackup(ctx, parent, timestamp, hosts[0]); err != nil {
 		return errors.Wrap(err, "schema backup failed")
 	}
 
-	// take snapshot on each host
-	// TODO: this could be done in parallel
+	// snapshot, upload and clean up each host concurrently, bounded by
+	// MaxParallelHosts. On the first failure the context is cancelled so
+	// in-flight hosts wind down, but every host is allowed to finish (or
+	// give up) before the errors are aggregated.
+	sem := make(chan struct{}, p.config.maxParallelHosts())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	merr := &multiError{}
+	var manifestEntries []manifestEntry
+	hostBytes := make(map[string]int64)
 	for _, host := range hosts {
-		glog.Infof("snapshot @ %s", host)
+		host := host
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			glog.Infof("snapshot @ %s", host)
+
+			// create snapshot
+			files, dirs, err := p.cassandra.Snapshot(ctx, host, timestamp)
+			if err != nil {
+				mu.Lock()
+				merr.add(errors.Wrapf(err, "snapshot @ %s", host))
+				mu.Unlock()
+				cancel()
+				return
+			}
+
+			// upload files to s3
+			if err = p.storage.UploadFiles(ctx, parent, timestamp, host, files); err != nil {
+				mu.Lock()
+				merr.add(errors.Wrapf(err, "upload @ %s", host))
+				mu.Unlock()
+				cancel()
+				return
+			}
+
+			// record per-file checksums for Verify
+			entries, err := buildManifestEntries(ctx, p.storage, p.config, parent, timestamp, host, files)
+			if err != nil {
+				mu.Lock()
+				merr.add(errors.Wrapf(err, "manifest @ %s", host))
+				mu.Unlock()
+				cancel()
+				return
+			}
+			var bytes int64
+			for _, e := range entries {
+				bytes += e.Size
+			}
+
+			mu.Lock()
+			manifestEntries = append(manifestEntries, entries...)
+			hostBytes[host] = bytes
+			mu.Unlock()
+
+			// delete local files
+			if err = p.cassandra.deleteSnapshot(ctx, host, timestamp, dirs); err != nil {
+				mu.Lock()
+				merr.add(errors.Wrapf(err, "delete @ %s", host))
+				mu.Unlock()
+				cancel()
+				return
+			}
+		}()
+	}
+	wg.Wait()
 
-		// create snapshot
-		files, dirs, err := p.cassandra.Snapshot(host, timestamp)
-		if err != nil {
-			return errors.Wrapf(err, "snapshot @ %s", host)
+	if err := merr.errOrNil(); err != nil {
+		glog.Errorf("backup failed, rolling back snapshot %s: %v", timestamp, err)
+		prefix := fmt.Sprintf("/%s/%s/%s/%s/", p.config.AwsBasePath, p.config.Keyspace, parent, timestamp)
+		if rbErr := p.storage.DeletePrefix(context.Background(), prefix); rbErr != nil {
+			return errors.Wrapf(err, "backup failed and rollback of %s also failed: %v", prefix, rbErr)
 		}
+		return errors.Wrap(err, "backup failed, snapshot rolled back")
+	}
 
-		// upload files to s3
-		if err = p.s3.UploadFiles(parent, timestamp, host, files); err != nil {
-			return errors.Wrapf(err, "upload @ %s", host)
-		}
+	if err := writeManifest(ctx, p.storage, p.config, parent, timestamp, manifestEntries); err != nil {
+		return errors.Wrap(err, "error writing manifest")
+	}
 
-		// delete local files
-		if err = p.cassandra.deleteSnapshot(host, dirs); err != nil {
-			return errors.Wrapf(err, "delete @ %s", host)
-		}
+	// Only now, once the whole backup has succeeded, record the bytes
+	// uploaded: a failed/rolled-back backup must not inflate this counter,
+	// since Prometheus counters can't be decremented.
+	for host, bytes := range hostBytes {
+		backupBytesTotal.WithLabelValues(host, p.config.Keyspace).Add(float64(bytes))
 	}
 	return nil
 }
 
-func (p *Priam) schemaBackup(parent, timestamp, host string) error {
+func (p *Priam) schemaBackup(ctx context.Context, parent, timestamp, host string) error {
 
 	// get schema backup
-	schemaFile, err := p.cassandra.SchemaBackup(host)
+	schemaFile, err := p.cassandra.SchemaBackup(ctx, host)
 	if err != nil {
 		return errors.Wrap(err, "schema backup")
 	}
@@ -119,7 +203,7 @@ func (p *Priam) schemaBackup(parent, timestamp, host string) error {
 		parent, timestamp, p.config.Keyspace)
 
 	// upload files to s3
-	if err = p.s3.UploadFile(host, schemaFile, key); err != nil {
+	if err = p.storage.UploadFile(ctx, host, schemaFile, key); err != nil {
 		return errors.Wrapf(err, "schema upload @ %s", host)
 	}
 
@@ -132,7 +216,7 @@ func (p *Priam) SnapshotHistory() error {
 		return nil
 	}
 	// get snapshot history from S3 if not already present
-	h, err := p.s3.SnapshotHistory()
+	h, err := p.storage.SnapshotHistory(context.Background())
 	if err != nil {
 		return errors.Wrap(err, "error getting snapshot history")
 	}
@@ -184,29 +268,32 @@ func (p *Priam) Restore() error {
 	}
 	glog.Infof("restoring to snapshot: %s", snapshot)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// drop keyspace
-	if err := p.deleteKeyspace(hosts[0]); err != nil {
+	if err := p.deleteKeyspace(ctx, hosts[0]); err != nil {
 		return errors.Wrap(err, "error deleting keyspace")
 	}
 
 	// create schema
-	if err := p.createSchema(hosts[0], snapshot); err != nil {
+	if err := p.createSchema(ctx, hosts[0], snapshot); err != nil {
 		return errors.Wrap(err, "error creating schema")
 	}
 
 	// load data
-	if err := p.loadSnapshot(hosts[0], snapshot); err != nil {
+	if err := p.loadSnapshot(ctx, hosts[0], snapshot); err != nil {
 		return errors.Wrap(err, "error loading snapshot")
 	}
 	return nil
 }
 
 // deleteKeyspace deletes keyspace.
-func (p *Priam) deleteKeyspace(host string) error {
+func (p *Priam) deleteKeyspace(ctx context.Context, host string) error {
 
 	cmd := fmt.Sprintf("echo 'DROP KEYSPACE IF EXISTS %s;' | %s",
 		p.config.Keyspace, p.config.CqlshPath)
-	_, err := p.agent.Run(host, cmd)
+	_, err := p.agent.Run(ctx, host, cmd)
 	if err != nil {
 		return err
 	}
@@ -214,7 +301,7 @@ func (p *Priam) deleteKeyspace(host string) error {
 }
 
 // createSchema creates the schema from backup for given snapshot.
-func (p *Priam) createSchema(host, snapshot string) error {
+func (p *Priam) createSchema(ctx context.Context, host, snapshot string) error {
 
 	// get parent
 	parent := p.hist.Parent(snapshot)
@@ -228,29 +315,30 @@ func (p *Priam) createSchema(host, snapshot string) error {
 	remoteTmpDir := fmt.Sprintf("%s/remote", p.config.TempDir)
 
 	// download schema file
-	localFile, err := p.s3.downloadKey(key, localTmpDir)
+	localFile, err := p.storage.DownloadKey(ctx, key, localTmpDir)
 	if err != nil {
 		return errors.Wrap(err, "error downloading schema key")
 	}
 
 	// copy schema file to cassandra host
 	remoteFile := strings.TrimSuffix(path.Join(remoteTmpDir, key), ".gz")
-	err = p.agent.UploadFile(host, localFile, path.Dir(remoteFile))
+	err = p.agent.UploadFile(ctx, host, localFile, path.Dir(remoteFile))
 	if err != nil {
 		return errors.Wrap(err, "error uploading file")
 	}
 
 	// create schema
 	cmd := fmt.Sprintf("cat %s | %s", remoteFile, p.config.CqlshPath)
-	_, err = p.agent.Run(host, cmd)
+	_, err = p.agent.Run(ctx, host, cmd)
 	if err != nil {
 		return errors.Wrap(err, "failed creating schema")
 	}
 	return nil
 }
 
-// loadSnapshot loads snapshot to cassandra.
-func (p *Priam) loadSnapshot(host, snapshot string) error {
+// loadSnapshot loads snapshot to cassandra, downloading and uploading keys
+// with up to Config.MaxParallelUploads concurrency.
+func (p *Priam) loadSnapshot(ctx context.Context, host, snapshot string) error {
 
 	localTmpDir := fmt.Sprintf("%s/local", p.config.TempDir)
 	remoteTmpDir := fmt.Sprintf("%s/remote", p.config.TempDir)
@@ -262,39 +350,58 @@ func (p *Priam) loadSnapshot(host, snapshot string) error {
 	}
 
 	// download keys
-	files, err := p.s3.downloadKeys(keys, localTmpDir)
+	files, err := p.storage.DownloadKeys(ctx, keys, localTmpDir)
 	if err != nil {
 		return errors.Wrap(err, "error downloading keys")
 	}
 
+	// verify every downloaded file against the snapshot's manifest before
+	// handing it to sstableloader
+	if err := p.verifyDownloadedFiles(ctx, snapshot, files); err != nil {
+		return errors.Wrap(err, "manifest verification failed")
+	}
+
 	// upload files to host
-	dirs, err := p.uploadFilesToHost(host, remoteTmpDir, files)
+	dirs, err := p.uploadFilesToHost(ctx, host, remoteTmpDir, files)
 	if err != nil {
 		return errors.Wrap(err, "could not upload files to host")
 	}
 
 	// run sstableload
-	err = p.cassandra.sstableload(host, dirs)
+	err = p.cassandra.sstableload(ctx, host, dirs)
 	if err != nil {
 		return errors.Wrap(err, "failed to run sstableloader")
 	}
 	return nil
 }
 
-// uploadFilesToHost copies cassandra files to a local directory on
-// one of the cassandra hosts.
-func (p *Priam) uploadFilesToHost(host, remoteTmpDir string,
+// uploadFilesToHost copies cassandra files to a local directory on one of
+// the cassandra hosts, with up to Config.MaxParallelUploads concurrent
+// transfers.
+func (p *Priam) uploadFilesToHost(ctx context.Context, host, remoteTmpDir string,
 	files map[string]string) (map[string]bool, error) {
 
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(p.config.maxParallelUploads())
+
 	dirs := make(map[string]bool)
+	var mu sync.Mutex
 	for key, localFile := range files {
-		glog.V(2).Infof("copy to %s: %s", host, key)
-		remoteDir := path.Dir(fmt.Sprintf("%s/%s", remoteTmpDir, key))
-		err := p.agent.UploadFile(host, localFile, remoteDir)
-		if err != nil {
-			return nil, errors.Wrap(err, "error uploading backup files to host")
-		}
-		dirs[remoteDir] = true
+		key, localFile := key, localFile
+		g.Go(func() error {
+			glog.V(2).Infof("copy to %s: %s", host, key)
+			remoteDir := path.Dir(fmt.Sprintf("%s/%s", remoteTmpDir, key))
+			if err := p.agent.UploadFile(gctx, host, localFile, remoteDir); err != nil {
+				return errors.Wrap(err, "error uploading backup files to host")
+			}
+			mu.Lock()
+			dirs[remoteDir] = true
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 	return dirs, nil
 }
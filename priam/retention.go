@@ -0,0 +1,175 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// snapshotTimestampFormat is the layout used to encode snapshot timestamps,
+// matching Priam.NewTimestamp.
+const snapshotTimestampFormat = "2006-01-02_150405"
+
+// RetentionPolicy describes how many snapshots to keep, mirroring restic's
+// forget semantics. Each Keep* field buckets snapshots by the given
+// granularity and keeps the newest one in every bucket until the count is
+// reached; KeepWithin additionally keeps everything newer than now minus
+// the duration. The union of every rule is kept.
+type RetentionPolicy struct {
+	KeepLast    int
+	KeepHourly  int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	KeepWithin  time.Duration
+
+	// DryRun, if true, only prints which snapshots would be pruned.
+	DryRun bool
+}
+
+// Forget applies policy to the snapshot history, deleting every snapshot
+// that isn't kept by at least one rule (or transitively required as the
+// parent of a kept incremental snapshot).
+func (p *Priam) Forget(policy RetentionPolicy) error {
+
+	// get snapshot history
+	if err := p.SnapshotHistory(); err != nil {
+		return errors.Wrap(err, "error getting snapshot history")
+	}
+
+	snapshots := p.hist.List()
+	if len(snapshots) == 0 {
+		glog.Infof("no snapshots to prune")
+		return nil
+	}
+
+	times := make(map[string]time.Time, len(snapshots))
+	for _, ts := range snapshots {
+		t, err := time.Parse(snapshotTimestampFormat, ts)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing snapshot timestamp %s", ts)
+		}
+		times[ts] = t
+	}
+
+	// newest to oldest
+	byAge := append([]string(nil), snapshots...)
+	sort.Sort(sort.Reverse(sort.StringSlice(byAge)))
+
+	keep := make(map[string]bool)
+	for i, ts := range byAge {
+		if policy.KeepLast > 0 && i < policy.KeepLast {
+			keep[ts] = true
+		}
+		if policy.KeepWithin > 0 && time.Since(times[ts]) <= policy.KeepWithin {
+			keep[ts] = true
+		}
+	}
+	keepBucketed(byAge, times, policy.KeepHourly, keep, func(t time.Time) string {
+		return t.Format("2006-01-02T15")
+	})
+	keepBucketed(byAge, times, policy.KeepDaily, keep, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepBucketed(byAge, times, policy.KeepWeekly, keep, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	})
+	keepBucketed(byAge, times, policy.KeepMonthly, keep, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+	keepBucketed(byAge, times, policy.KeepYearly, keep, func(t time.Time) string {
+		return t.Format("2006")
+	})
+
+	closeOverParents(keep, p.hist)
+
+	var prune []string
+	for _, ts := range snapshots {
+		if !keep[ts] {
+			prune = append(prune, ts)
+		}
+	}
+	if len(prune) == 0 {
+		glog.Infof("retention policy keeps all %d snapshots", len(snapshots))
+		return nil
+	}
+
+	if policy.DryRun {
+		fmt.Printf("dry run, would prune %d snapshot(s):\n", len(prune))
+		for _, ts := range prune {
+			fmt.Printf("  %s\n", ts)
+		}
+		return nil
+	}
+
+	var keys []string
+	for _, ts := range prune {
+		k, err := p.hist.Keys(ts)
+		if err != nil {
+			return errors.Wrapf(err, "error getting keys for snapshot %s", ts)
+		}
+		keys = append(keys, k...)
+	}
+
+	glog.Infof("pruning %d snapshot(s), %d object(s)", len(prune), len(keys))
+	const deleteBatchSize = 1000
+	for i := 0; i < len(keys); i += deleteBatchSize {
+		end := i + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := p.storage.DeleteKeys(context.Background(), keys[i:end]); err != nil {
+			return errors.Wrap(err, "error deleting pruned objects")
+		}
+	}
+
+	// invalidate the cached history so the next caller sees the pruned state
+	p.hist = nil
+	return p.SnapshotHistory()
+}
+
+// closeOverParents extends keep with the transitive closure of each kept
+// snapshot's parent chain: a kept incremental snapshot needs its whole
+// parent chain kept too, or loadSnapshot would have nothing to apply its
+// deltas on top of.
+func closeOverParents(keep map[string]bool, hist *SnapshotHistory) {
+	for ts := range keep {
+		for cur := ts; ; {
+			parent := hist.Parent(cur)
+			if parent == "" || parent == cur || keep[parent] {
+				break
+			}
+			keep[parent] = true
+			cur = parent
+		}
+	}
+}
+
+// keepBucketed walks snapshots newest to oldest and marks the first
+// snapshot in each not-yet-seen bucket as kept, until limit buckets have
+// been filled.
+func keepBucketed(byAge []string, times map[string]time.Time, limit int,
+	keep map[string]bool, bucket func(time.Time) string) {
+
+	if limit <= 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, ts := range byAge {
+		if len(seen) >= limit {
+			return
+		}
+		b := bucket(times[ts])
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		keep[ts] = true
+	}
+}
@@ -0,0 +1,58 @@
+package priam
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepBucketedKeepsNewestPerBucket(t *testing.T) {
+	times := map[string]time.Time{
+		"a": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		"b": time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+		"c": time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+	}
+	byAge := []string{"c", "a", "b"} // newest to oldest
+
+	keep := make(map[string]bool)
+	keepBucketed(byAge, times, 1, keep, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+
+	if !keep["c"] {
+		t.Errorf("expected the newest snapshot in the only requested bucket (c) to be kept")
+	}
+	if keep["a"] || keep["b"] {
+		t.Errorf("expected keep-daily=1 to stop after the first bucket, got keep=%v", keep)
+	}
+}
+
+func TestKeepBucketedZeroLimitKeepsNothing(t *testing.T) {
+	times := map[string]time.Time{"a": time.Now()}
+	keep := make(map[string]bool)
+	keepBucketed([]string{"a"}, times, 0, keep, func(t time.Time) string { return "x" })
+	if len(keep) != 0 {
+		t.Errorf("expected a zero limit to keep nothing, got %v", keep)
+	}
+}
+
+// TestCloseOverParentsKeepsWholeChain verifies that closeOverParents (used
+// by Forget) keeps the whole parent chain of a kept incremental snapshot,
+// not just the snapshot itself - loadSnapshot would otherwise have nothing
+// to apply an orphaned incremental's deltas on top of.
+func TestCloseOverParentsKeepsWholeChain(t *testing.T) {
+	// full -> inc1 -> inc2, only inc2 requested kept.
+	hist := newSnapshotHistory("/base/ks/", []string{
+		"/base/ks/full/full/hostA/f1",
+		"/base/ks/full/inc1/hostA/f2",
+		"/base/ks/inc1/inc2/hostA/f3",
+	})
+
+	keep := map[string]bool{"inc2": true}
+	closeOverParents(keep, hist)
+
+	for _, want := range []string{"full", "inc1", "inc2"} {
+		if !keep[want] {
+			t.Errorf("expected %s to be transitively kept, keep=%v", want, keep)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// S3 stores and retrieves backup artifacts from an AWS S3 bucket.
+type S3 struct {
+	config     *Config
+	agent      *Agent
+	client     *s3.S3
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
+}
+
+// NewS3 returns a new S3.
+func NewS3(config *Config, agent *Agent) *S3 {
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion(config.AwsRegion)))
+	return &S3{
+		config:     config,
+		agent:      agent,
+		client:     s3.New(sess),
+		uploader:   s3manager.NewUploader(sess),
+		downloader: s3manager.NewDownloader(sess),
+	}
+}
+
+// UploadFile uploads localFile to key in the backup bucket.
+func (s *S3) UploadFile(ctx context.Context, host, localFile, key string) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", localFile)
+	}
+	defer f.Close()
+
+	_, err = s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.config.S3Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error uploading %s to %s", localFile, key)
+	}
+	return nil
+}
+
+// UploadFiles uploads files for host under the parent/timestamp prefix,
+// using up to Config.MaxParallelUploads concurrent file uploads. The first
+// upload failure cancels the remaining uploads and is returned.
+func (s *S3) UploadFiles(ctx context.Context, parent, timestamp, host string, files []string) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.config.maxParallelUploads())
+
+	for _, file := range files {
+		file := file
+		key := fmt.Sprintf("/%s/%s/%s/%s/%s%s",
+			s.config.AwsBasePath, s.config.Keyspace, parent, timestamp, host, file)
+		g.Go(func() error {
+			return s.UploadFile(gctx, host, file, key)
+		})
+	}
+	return g.Wait()
+}
+
+// CreateIfAbsent uploads localFile to key using a conditional PUT
+// (If-None-Match: *), so two callers racing to create the same key can
+// never both succeed. aws-sdk-go (v1)'s PutObjectInput has no IfNoneMatch
+// field - that only exists on the v2 SDK - so the header is set directly
+// on the low-level request instead of going through PutObjectWithContext.
+func (s *S3) CreateIfAbsent(ctx context.Context, key, localFile string) (bool, error) {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "error opening %s", localFile)
+	}
+	defer f.Close()
+
+	req, _ := s.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(s.config.S3Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	req.SetContext(ctx)
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+
+	if err := req.Send(); err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "PreconditionFailed" {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "error creating %s", key)
+	}
+	return true, nil
+}
+
+// StatKey reports the stored size of key via a HEAD request.
+func (s *S3) StatKey(ctx context.Context, key string) (int64, bool, error) {
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.config.S3Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// HeadObject has no response body to carry s3.ErrCodeNoSuchKey (that
+		// code is only ever returned by GetObject); a missing key surfaces
+		// as "NotFound" instead, per the SDK's own s3/doc_custom.go.
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NotFound" {
+			return 0, false, nil
+		}
+		return 0, false, errors.Wrapf(err, "error heading %s", key)
+	}
+	return aws.Int64Value(out.ContentLength), true, nil
+}
+
+// DeleteKeys batch-deletes keys from the backup bucket.
+func (s *S3) DeleteKeys(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	objects := make([]*s3.ObjectIdentifier, len(keys))
+	for i, key := range keys {
+		objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+	}
+	_, err := s.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.config.S3Bucket),
+		Delete: &s3.Delete{Objects: objects},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error deleting keys")
+	}
+	return nil
+}
+
+// ListPrefix returns every object key stored under prefix.
+func (s *S3) ListPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.S3Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing prefix %s", prefix)
+	}
+	return keys, nil
+}
+
+// DeletePrefix removes every object under prefix, used to roll back a
+// partially written snapshot.
+func (s *S3) DeletePrefix(ctx context.Context, prefix string) error {
+	keys, err := s.ListPrefix(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return s.DeleteKeys(ctx, keys)
+}
+
+// DownloadKey downloads key to localDir and returns the path to the local
+// file.
+func (s *S3) DownloadKey(ctx context.Context, key, localDir string) (string, error) {
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "error creating %s", localDir)
+	}
+	localFile := filepath.Join(localDir, path.Base(key))
+	f, err := os.Create(localFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating %s", localFile)
+	}
+	defer f.Close()
+
+	_, err = s.downloader.DownloadWithContext(ctx, f, &s3.GetObjectInput{
+		Bucket: aws.String(s.config.S3Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "error downloading %s", key)
+	}
+	return localFile, nil
+}
+
+// DownloadKeys downloads keys to localDir, using up to
+// Config.MaxParallelUploads concurrent downloads, and returns a map of key
+// to local file path.
+func (s *S3) DownloadKeys(ctx context.Context, keys []string, localDir string) (map[string]string, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.config.maxParallelUploads())
+
+	files := make(map[string]string, len(keys))
+	var mu sync.Mutex
+	for _, key := range keys {
+		key := key
+		g.Go(func() error {
+			localFile, err := s.DownloadKey(gctx, key, filepath.Join(localDir, path.Dir(key)))
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			files[key] = localFile
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// SnapshotHistory fetches and parses the list of snapshots present in the
+// backup bucket.
+func (s *S3) SnapshotHistory(ctx context.Context) (*SnapshotHistory, error) {
+	prefix := fmt.Sprintf("/%s/%s/", s.config.AwsBasePath, s.config.Keyspace)
+	keys, err := s.ListPrefix(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing snapshot history")
+	}
+	return newSnapshotHistory(prefix, keys), nil
+}
@@ -0,0 +1,30 @@
+package priam
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TestS3CreateIfAbsentSetsIfNoneMatchHeader is a compile-time and
+// behavioral guard against regressing to a PutObjectInput.IfNoneMatch
+// field reference: that field exists on the aws-sdk-go-v2 PutObjectInput,
+// not on this package's v1 SDK, and using it fails to build. CreateIfAbsent
+// instead sets the header on the low-level request; this test exercises
+// that exact construction.
+func TestS3CreateIfAbsentSetsIfNoneMatchHeader(t *testing.T) {
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1")))
+	client := s3.New(sess)
+
+	req, _ := client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("key"),
+	})
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+
+	if got, want := req.HTTPRequest.Header.Get("If-None-Match"), "*"; got != want {
+		t.Fatalf("If-None-Match header = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+package priam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
+)
+
+// ScheduleConfig carries the cron expressions that drive priam server's
+// scheduled runs.
+type ScheduleConfig struct {
+	Full        string
+	Incremental string
+	Retention   string
+}
+
+var (
+	backupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "priam_backup_duration_seconds",
+		Help: "Duration of completed backup runs.",
+	})
+	backupBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "priam_backup_bytes_total",
+		Help: "Total bytes uploaded per host and keyspace.",
+	}, []string{"host", "keyspace"})
+	snapshotLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "priam_snapshot_last_success_timestamp",
+		Help: "Unix timestamp of the last successful backup.",
+	})
+	uploadErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "priam_upload_errors_total",
+		Help: "Total number of failed scheduled backup runs.",
+	})
+	snapshotsTotal = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "priam_snapshots_total",
+		Help: "Number of snapshots currently retained.",
+	})
+)
+
+// Serve runs priam as a long-lived daemon: it schedules Backup and Forget
+// on the cadence in Config.Schedule, skipping a run rather than stacking
+// it if the previous one is still in flight, and serves /metrics,
+// /healthz and /snapshots on addr until ctx is cancelled.
+func (p *Priam) Serve(ctx context.Context, addr string) error {
+
+	elector, err := p.newLeaderElector()
+	if err != nil {
+		return errors.Wrap(err, "error configuring leader election")
+	}
+
+	var running int32 // 0 = idle, 1 = a scheduled run is in flight
+
+	runGuarded := func(name string, backup bool, fn func() error) {
+		if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+			glog.Warningf("skipping scheduled %s, a run is already in flight", name)
+			return
+		}
+		defer atomic.StoreInt32(&running, 0)
+
+		if ok, err := elector.IsLeader(ctx); err != nil {
+			glog.Errorf("leader election error, skipping %s: %v", name, err)
+			return
+		} else if !ok {
+			glog.V(2).Infof("not leader, skipping %s", name)
+			return
+		}
+
+		glog.Infof("starting scheduled %s", name)
+		start := time.Now()
+		if err := fn(); err != nil {
+			glog.Errorf("scheduled %s failed: %v", name, err)
+			if backup {
+				uploadErrorsTotal.Inc()
+			}
+			return
+		}
+		if backup {
+			backupDuration.Observe(time.Since(start).Seconds())
+			snapshotLastSuccess.Set(float64(time.Now().Unix()))
+		}
+		updateSnapshotsTotal(ctx, p)
+	}
+
+	c := cron.New()
+	if p.config.Schedule.Full != "" {
+		if _, err := c.AddFunc(p.config.Schedule.Full, func() {
+			runGuarded("full backup", true, func() error {
+				p.config.Incremental = false
+				return p.Backup()
+			})
+		}); err != nil {
+			return errors.Wrap(err, "error scheduling full backup")
+		}
+	}
+	if p.config.Schedule.Incremental != "" {
+		if _, err := c.AddFunc(p.config.Schedule.Incremental, func() {
+			runGuarded("incremental backup", true, func() error {
+				p.config.Incremental = true
+				return p.Backup()
+			})
+		}); err != nil {
+			return errors.Wrap(err, "error scheduling incremental backup")
+		}
+	}
+	if p.config.Schedule.Retention != "" {
+		if _, err := c.AddFunc(p.config.Schedule.Retention, func() {
+			runGuarded("retention", false, func() error {
+				return p.Forget(p.config.RetentionPolicy)
+			})
+		}); err != nil {
+			return errors.Wrap(err, "error scheduling retention")
+		}
+	}
+	c.Start()
+	defer c.Stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		if err := p.SnapshotHistory(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		snapshots := p.hist.List()
+		snapshotsTotal.Set(float64(len(snapshots)))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshots)
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return errors.Wrap(err, "error running metrics server")
+	}
+}
+
+// updateSnapshotsTotal refreshes priam_snapshots_total from storage, so a
+// bare /metrics scrape reflects the real retained count even if nothing
+// has hit /snapshots. It is called after every scheduled backup/retention
+// run, not just from the /snapshots handler.
+func updateSnapshotsTotal(ctx context.Context, p *Priam) {
+	hist, err := p.storage.SnapshotHistory(ctx)
+	if err != nil {
+		glog.Warningf("error refreshing priam_snapshots_total metric: %v", err)
+		return
+	}
+	snapshotsTotal.Set(float64(len(hist.List())))
+}
+
+// newLeaderElector builds the LeaderElector configured by Config.LeaderLock
+// ("none", "file" or "storage").
+func (p *Priam) newLeaderElector() (LeaderElector, error) {
+	switch p.config.LeaderLock {
+	case "", "none":
+		return singleReplicaElector{}, nil
+	case "file":
+		if p.config.LeaderLockPath == "" {
+			return nil, fmt.Errorf("LeaderLockPath must be set when LeaderLock is \"file\"")
+		}
+		return newFileLeaderElector(p.config.LeaderLockPath), nil
+	case "storage":
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting hostname for leader identity")
+		}
+		// Lease objects are created unencrypted via CreateIfAbsent, so they
+		// are also read and renewed on the raw backend.
+		backend := p.storage
+		if cs, ok := backend.(*cryptoStorage); ok {
+			backend = cs.inner
+		}
+		return newStorageLeaderElector(backend, p.config.AwsBasePath, hostname), nil
+	default:
+		return nil, fmt.Errorf("unsupported leader lock mode %q", p.config.LeaderLock)
+	}
+}
@@ -0,0 +1,82 @@
+package priam
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SnapshotHistory indexes the snapshots present in the backup bucket,
+// keyed by timestamp, along with the keys that belong to each one.
+type SnapshotHistory struct {
+	prefix     string
+	parents    map[string]string   // timestamp -> parent timestamp
+	keys       map[string][]string // timestamp -> object keys
+	timestamps []string            // sorted oldest to newest
+}
+
+// newSnapshotHistory builds a SnapshotHistory from the raw object keys
+// listed under prefix. Keys are expected to look like
+// "<prefix><parent>/<timestamp>/<rest>".
+func newSnapshotHistory(prefix string, objectKeys []string) *SnapshotHistory {
+	h := &SnapshotHistory{
+		prefix:  prefix,
+		parents: make(map[string]string),
+		keys:    make(map[string][]string),
+	}
+	for _, key := range objectKeys {
+		rest := strings.TrimPrefix(key, prefix)
+		parts := strings.SplitN(rest, "/", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		parent, timestamp := parts[0], parts[1]
+		if _, ok := h.parents[timestamp]; !ok {
+			h.parents[timestamp] = parent
+			h.timestamps = append(h.timestamps, timestamp)
+		}
+		h.keys[timestamp] = append(h.keys[timestamp], key)
+	}
+	sort.Strings(h.timestamps)
+	return h
+}
+
+// List returns every known snapshot timestamp, oldest to newest.
+func (h *SnapshotHistory) List() []string {
+	return h.timestamps
+}
+
+// Valid reports whether snapshot is a known timestamp.
+func (h *SnapshotHistory) Valid(snapshot string) bool {
+	_, ok := h.parents[snapshot]
+	return ok
+}
+
+// Parent returns the parent timestamp of snapshot, or snapshot itself if it
+// is a full (non-incremental) snapshot.
+func (h *SnapshotHistory) Parent(snapshot string) string {
+	return h.parents[snapshot]
+}
+
+// Keys returns the object keys belonging to snapshot.
+func (h *SnapshotHistory) Keys(snapshot string) ([]string, error) {
+	keys, ok := h.keys[snapshot]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid snapshot", snapshot)
+	}
+	return keys, nil
+}
+
+// String renders the snapshot history as a human readable list.
+func (h *SnapshotHistory) String() string {
+	var sb strings.Builder
+	for _, ts := range h.timestamps {
+		parent := h.parents[ts]
+		if parent == ts {
+			fmt.Fprintf(&sb, "%s (full)\n", ts)
+		} else {
+			fmt.Fprintf(&sb, "%s (incremental, parent %s)\n", ts, parent)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,40 @@
+package priam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewSnapshotHistory(t *testing.T) {
+	prefix := "/base/ks/"
+	keys := []string{
+		"/base/ks/2024-01-01_020000/2024-01-01_020000/hostA/file1",
+		"/base/ks/2024-01-01_020000/2024-01-01_030000/hostA/file2",
+	}
+	h := newSnapshotHistory(prefix, keys)
+
+	if got, want := h.List(), []string{"2024-01-01_020000", "2024-01-01_030000"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+	if !h.Valid("2024-01-01_020000") {
+		t.Fatalf("expected 2024-01-01_020000 to be valid")
+	}
+	if got, want := h.Parent("2024-01-01_020000"), "2024-01-01_020000"; got != want {
+		t.Fatalf("Parent(full) = %q, want %q", got, want)
+	}
+	if got, want := h.Parent("2024-01-01_030000"), "2024-01-01_020000"; got != want {
+		t.Fatalf("Parent(incremental) = %q, want %q", got, want)
+	}
+}
+
+// TestNewSnapshotHistorySkipsKeysMissingATimestampSegment guards against
+// any key listed under prefix that doesn't have at least a
+// "<parent>/<timestamp>/" shape (newSnapshotHistory requires len(parts) >=
+// 2 and skips the rest).
+func TestNewSnapshotHistorySkipsKeysMissingATimestampSegment(t *testing.T) {
+	prefix := "/base/ks/"
+	h := newSnapshotHistory(prefix, []string{"/base/ks/manifest.json"})
+	if got := h.List(); len(got) != 0 {
+		t.Fatalf("List() = %v, want empty", got)
+	}
+}
@@ -0,0 +1,90 @@
+package priam
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Storage is the backend priam persists and retrieves backup artifacts
+// from. S3 is the original implementation; localfs and gcs let priam run
+// against an NFS mount or Google Cloud Storage instead.
+type Storage interface {
+	// UploadFile uploads localFile to key.
+	UploadFile(ctx context.Context, host, localFile, key string) error
+	// UploadFiles uploads files for host under the parent/timestamp prefix.
+	UploadFiles(ctx context.Context, parent, timestamp, host string, files []string) error
+	// DownloadKey downloads key to localDir and returns the local path.
+	DownloadKey(ctx context.Context, key, localDir string) (string, error)
+	// DownloadKeys downloads keys to localDir, returning a map of key to
+	// local path.
+	DownloadKeys(ctx context.Context, keys []string, localDir string) (map[string]string, error)
+	// ListPrefix returns every key stored under prefix.
+	ListPrefix(ctx context.Context, prefix string) ([]string, error)
+	// StatKey reports the stored size of key and whether it exists, used
+	// by Verify to shallow-check a snapshot without downloading it.
+	StatKey(ctx context.Context, key string) (size int64, exists bool, err error)
+	// DeleteKeys batch-deletes keys.
+	DeleteKeys(ctx context.Context, keys []string) error
+	// DeletePrefix removes every key under prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// SnapshotHistory fetches and parses the snapshot history.
+	SnapshotHistory(ctx context.Context) (*SnapshotHistory, error)
+	// CreateIfAbsent uploads localFile to key only if key does not already
+	// exist, as an atomic conditional create. It reports created=false
+	// (with a nil error) when key was already present, used to implement a
+	// distributed lock without a lost-update race.
+	CreateIfAbsent(ctx context.Context, key, localFile string) (created bool, err error)
+}
+
+// newStorage selects and constructs a Storage backend for config, wrapping
+// it with client-side compression and encryption when Config.Passphrase is
+// set.
+func newStorage(config *Config, agent *Agent) (Storage, error) {
+	storage, err := selectBackend(config, agent)
+	if err != nil {
+		return nil, err
+	}
+	if config.Passphrase != "" {
+		return newCryptoStorage(storage, config, agent)
+	}
+	return storage, nil
+}
+
+// selectBackend constructs the raw, unencrypted Storage backend for
+// config. The backend is chosen from Config.BackendType, falling back to
+// the scheme of Config.BackendURL (e.g. "s3://bucket/path",
+// "gs://bucket/path", "file:///mnt/backups") when BackendType is unset.
+func selectBackend(config *Config, agent *Agent) (Storage, error) {
+	backendType := config.BackendType
+	if backendType == "" && config.BackendURL != "" {
+		u, err := url.Parse(config.BackendURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing backend url %s", config.BackendURL)
+		}
+		backendType = u.Scheme
+		switch backendType {
+		case "s3":
+			config.S3Bucket = u.Host
+			config.AwsBasePath = u.Path
+		case "gs":
+			config.GCSBucket = u.Host
+			config.AwsBasePath = u.Path
+		case "file":
+			config.LocalFSPath = u.Path
+		}
+	}
+
+	switch backendType {
+	case "", "s3":
+		return NewS3(config, agent), nil
+	case "gs", "gcs":
+		return NewGCS(config, agent)
+	case "file", "localfs":
+		return NewLocalFS(config, agent), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", backendType)
+	}
+}
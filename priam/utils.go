@@ -0,0 +1,22 @@
+package priam
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// writeTempFile writes contents to a new file named name under dir,
+// creating dir if necessary, and returns the path to the file.
+func writeTempFile(dir, name, contents string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "error creating temp dir %s", dir)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		return "", errors.Wrapf(err, "error writing temp file %s", path)
+	}
+	return path, nil
+}